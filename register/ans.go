@@ -0,0 +1,119 @@
+/* license: https://mit-license.org
+ * ==============================================================================
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2021 Albert Moky
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ * ==============================================================================
+ */
+package main
+
+import (
+	"fmt"
+	. "github.com/dimchat/demo-go/sdk/common"
+	. "github.com/dimchat/demo-go/sdk/common/db"
+	. "github.com/dimchat/demo-go/sdk/database"
+	. "github.com/dimchat/mkm-go/protocol"
+	"sort"
+	"strings"
+)
+
+// alias will be saved in a line with tab separator, and must not look like an ID
+func checkAlias(alias string) bool {
+	if alias == "" || strings.ContainsAny(alias, " \t\r\n@/") {
+		fmt.Println("!!! alias error:", alias)
+		return false
+	} else if IsReservedName(alias) {
+		fmt.Println("!!! alias is reserved:", alias)
+		return false
+	}
+	return true
+}
+
+func ansAdd(alias string, identifier ID) bool {
+	if checkAlias(alias) == false {
+		return false
+	}
+	old := SharedDatabase().GetIdentifier(alias)
+	if SharedAddressNameService().Save(alias, identifier) == false {
+		fmt.Println("!!! failed to save ANS record:", alias)
+		return false
+	}
+	if old == nil {
+		fmt.Printf("  + %-12s %s\n", alias+":", identifier)
+	} else {
+		fmt.Printf("  * %-12s %s -> %s\n", alias+":", old, identifier)
+	}
+	return true
+}
+
+func ansRemove(alias string) bool {
+	if checkAlias(alias) == false {
+		return false
+	}
+	old := SharedDatabase().GetIdentifier(alias)
+	if old == nil {
+		fmt.Println("!!! ANS record not found:", alias)
+		return false
+	}
+	if SharedAddressNameService().Save(alias, nil) == false {
+		fmt.Println("!!! failed to remove ANS record:", alias)
+		return false
+	}
+	fmt.Printf("  - %-12s %s\n", alias+":", old)
+	return true
+}
+
+func ansList() bool {
+	records := SharedDatabase().AllRecords()
+	aliases := make([]string, 0, len(records))
+	for alias := range records {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	for _, alias := range aliases {
+		flag := " "
+		if IsReservedName(alias) {
+			flag = "*"
+		}
+		fmt.Printf("  %s %-12s %s\n", flag, alias+":", records[alias])
+	}
+	return true
+}
+
+func doANS(path string, args []string) bool {
+	if len(args) > 0 {
+		cmd := args[0]
+		if cmd == "list" {
+			return ansList()
+		} else if cmd == "remove" && len(args) == 2 {
+			return ansRemove(args[1])
+		} else if cmd == "add" && len(args) == 3 {
+			identifier := IDParse(args[2])
+			if identifier == nil {
+				fmt.Println("!!! ID error:", args[2])
+				return false
+			}
+			return ansAdd(args[1], identifier)
+		}
+	}
+	doHelp(path, []string{"ans"})
+	return false
+}
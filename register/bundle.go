@@ -0,0 +1,156 @@
+/* license: https://mit-license.org
+ * ==============================================================================
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2021 Albert Moky
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ * ==============================================================================
+ */
+package main
+
+import (
+	"fmt"
+	. "github.com/dimchat/demo-go/sdk/client"
+	. "github.com/dimchat/demo-go/sdk/extensions"
+	. "github.com/dimchat/demo-go/sdk/utils"
+	. "github.com/dimchat/mkm-go/crypto"
+	. "github.com/dimchat/mkm-go/protocol"
+	"os"
+)
+
+/**
+ *  Get passphrase for account bundle from:
+ *      1. environment variable 'DIM_BUNDLE_PASSPHRASE'
+ *      2. terminal input
+ */
+func getBundlePassphrase(confirm bool) string {
+	password := os.Getenv("DIM_BUNDLE_PASSPHRASE")
+	if password != "" {
+		return password
+	}
+	password = readPassphrase("Bundle passphrase: ")
+	if confirm && password != "" {
+		if readPassphrase("Confirm bundle passphrase: ") != password {
+			fmt.Println("!!! passphrases not match")
+			return ""
+		}
+	}
+	return password
+}
+
+func doExport(path string, args []string) bool {
+	out := getOptionString(args, "--out")
+	if len(args) == 0 || out == "" {
+		doHelp(path, []string{"export"})
+		return false
+	}
+	identifier := IDParse(args[0])
+	if identifier == nil || identifier.IsUser() == false {
+		fmt.Println("!!! user ID error:", args[0])
+		return false
+	}
+	facebook := SharedFacebook()
+	bundle := new(AccountBundle)
+	bundle.ID = identifier
+	bundle.Meta = facebook.GetMeta(identifier)
+	bundle.Visa = getDocument(identifier)
+	bundle.IdentityKey, _ = facebook.GetPrivateKeyForVisaSignature(identifier).(PrivateKey)
+	if bundle.Meta == nil || bundle.Visa == nil {
+		fmt.Println("!!! meta/document not found:", identifier)
+		return false
+	} else if bundle.IdentityKey == nil {
+		fmt.Println("!!! private key not found:", identifier)
+		return false
+	}
+	for _, item := range facebook.GetPrivateKeysForDecryption(identifier) {
+		key, ok := item.(PrivateKey)
+		if ok && key != nil && key.Equal(bundle.IdentityKey) == false {
+			bundle.CommunicationKeys = append(bundle.CommunicationKeys, key)
+		}
+	}
+	password := getBundlePassphrase(true)
+	if password == "" {
+		fmt.Println("!!! passphrase required for account bundle")
+		return false
+	}
+	info := AccountBundleSeal(bundle, password)
+	if info == nil || WriteJSONFile(out, info) == false {
+		fmt.Println("!!! failed to write account bundle:", out)
+		return false
+	}
+	fmt.Println("******** ID:", identifier)
+	fmt.Println("******** account exported to:", out)
+	return true
+}
+
+func doImport(path string, args []string) bool {
+	if len(args) == 0 {
+		doHelp(path, []string{"import"})
+		return false
+	}
+	info, ok := ReadJSONFile(args[0]).(map[string]interface{})
+	if !ok {
+		fmt.Println("!!! failed to read account bundle:", args[0])
+		return false
+	}
+	bundle := AccountBundleOpen(info, getBundlePassphrase(false))
+	if bundle == nil {
+		fmt.Println("!!! wrong passphrase or invalid account bundle:", args[0])
+		return false
+	}
+	identifier := bundle.ID
+	// verify before writing anything
+	if MetaMatchID(bundle.Meta, identifier) == false {
+		fmt.Println("!!! meta not match ID:", identifier)
+		return false
+	}
+	if identifier.Equal(bundle.Visa.ID()) == false || bundle.Visa.Verify(bundle.Meta.Key()) == false {
+		fmt.Println("!!! visa signature error:", identifier)
+		return false
+	}
+	facebook := SharedFacebook()
+	old, _ := facebook.GetPrivateKeyForVisaSignature(identifier).(PrivateKey)
+	if old != nil && old.Equal(bundle.IdentityKey) == false {
+		fmt.Println("!!! another identity key exists:", identifier)
+		return false
+	}
+	// save private keys
+	if old == nil && facebook.SavePrivateKey(bundle.IdentityKey, "M", identifier) == false {
+		fmt.Println("!!! failed to save identity key:", identifier)
+		return false
+	}
+	count := len(bundle.CommunicationKeys)
+	for index := count - 1; index >= 0; index-- {
+		// the latest one will be moved to the front
+		facebook.SavePrivateKey(bundle.CommunicationKeys[index], "V", identifier)
+	}
+	// save meta & visa
+	if facebook.SaveMeta(bundle.Meta, identifier) == false {
+		fmt.Println("!!! failed to save meta:", identifier)
+		return false
+	}
+	if facebook.SaveDocument(bundle.Visa) == false {
+		// maybe a newer one exists
+		fmt.Println("!!! visa not saved:", identifier)
+	}
+	fmt.Println("******** ID:", identifier)
+	fmt.Println("******** account imported from:", args[0])
+	return true
+}
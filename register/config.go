@@ -0,0 +1,132 @@
+/* license: https://mit-license.org
+ * ==============================================================================
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2021 Albert Moky
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ * ==============================================================================
+ */
+package main
+
+import (
+	"fmt"
+	. "github.com/dimchat/demo-go/sdk/utils"
+	"os"
+)
+
+/**
+ *  Global Options
+ *  ~~~~~~~~~~~~~~
+ *
+ *  Options before the command, override values in config file:
+ *
+ *      --root <dir>                Storage root directory (env: DIM_ROOT)
+ *      --config <file>             JSON config file, e.g.:
+ *                                      {
+ *                                          "root": "/var/dim",
+ *                                          "log_level": "release",
+ *                                          "passphrase_file": "/etc/dim/passphrase"
+ *                                      }
+ *      --log-level <level>         "debug", "develop" or "release" (env: DIM_LOG_LEVEL)
+ *      --passphrase-file <file>    File contains passphrase (env: DIM_PASSPHRASE_FILE)
+ */
+type globalOptions struct {
+	root string
+	logLevel string
+	passphraseFile string
+}
+
+var globalKeys = map[string]bool{
+	"--root": true,
+	"--config": true,
+	"--log-level": true,
+	"--passphrase-file": true,
+}
+
+// parse global options before the command, return the rest arguments
+func parseGlobalOptions(args []string) (*globalOptions, []string) {
+	opts := new(globalOptions)
+	var config string
+	pos := 0
+	for pos + 1 < len(args) && globalKeys[args[pos]] {
+		key := args[pos]
+		value := trimQuotations(args[pos + 1])
+		if key == "--root" {
+			opts.root = value
+		} else if key == "--config" {
+			config = value
+		} else if key == "--log-level" {
+			opts.logLevel = value
+		} else if key == "--passphrase-file" {
+			opts.passphraseFile = value
+		}
+		pos += 2
+	}
+	if config != "" && !loadConfig(opts, config) {
+		return nil, args[pos:]
+	}
+	return opts, args[pos:]
+}
+
+// fill options missing from command line with values in config file
+func loadConfig(opts *globalOptions, path string) bool {
+	if !PathIsExist(path) {
+		fmt.Printf("config file not found: %s\n", path)
+		return false
+	}
+	info, ok := ReadJSONFile(path).(map[string]interface{})
+	if !ok {
+		fmt.Printf("config file error: %s\n", path)
+		return false
+	}
+	if opts.root == "" {
+		opts.root, _ = info["root"].(string)
+	}
+	if opts.logLevel == "" {
+		opts.logLevel, _ = info["log_level"].(string)
+	}
+	if opts.passphraseFile == "" {
+		opts.passphraseFile, _ = info["passphrase_file"].(string)
+	}
+	return true
+}
+
+func applyGlobalOptions(opts *globalOptions) bool {
+	if opts.logLevel != "" && !SetLogLevel(opts.logLevel) {
+		fmt.Printf("unknown log level: %s\n", opts.logLevel)
+		return false
+	}
+	if opts.passphraseFile != "" {
+		_ = os.Setenv("DIM_PASSPHRASE_FILE", opts.passphraseFile)
+	}
+	if opts.root != "" {
+		storage := getStorage()
+		if storage == nil {
+			fmt.Println("storage root only works with file database")
+			return false
+		}
+		storage.SetRoot(opts.root)
+		if storage.Root() != opts.root {
+			fmt.Printf("failed to open storage root: %s\n", opts.root)
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,59 @@
+/* license: https://mit-license.org
+ * ==============================================================================
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2021 Albert Moky
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ * ==============================================================================
+ */
+package main
+
+import (
+	"fmt"
+)
+
+func doFsck(path string, args []string) bool {
+	storage := getStorage()
+	if storage == nil {
+		fmt.Println("!!! fsck only works with file database")
+		return false
+	}
+	repair := hasOption(args, "--repair")
+	fmt.Println("******** checking:", storage.Root())
+	issues := storage.Fsck(repair)
+	repaired := 0
+	for _, item := range issues {
+		flag := "!"
+		if item.Repaired {
+			flag = "*"
+			repaired++
+		}
+		fmt.Printf("  %s %s\n      %s\n", flag, item.Path, item.Reason)
+	}
+	if len(issues) == 0 {
+		fmt.Println("OK, no problem found.")
+		return true
+	} else if repair {
+		fmt.Printf("%d problem(s) found, %d repaired.\n", len(issues), repaired)
+	} else {
+		fmt.Printf("%d problem(s) found, run with '--repair' to quarantine broken records.\n", len(issues))
+	}
+	return repaired == len(issues)
+}
@@ -36,13 +36,16 @@ import (
 
 func getUserInfo(identifier ID) *UserInfo {
 	facebook := SharedFacebook()
-	return &UserInfo{
+	info := &UserInfo{
 		ID: identifier,
 		Meta: facebook.GetMeta(identifier),
 		Visa: facebook.GetDocument(identifier, VISA),
 		IdentityKey: facebook.GetPrivateKeyForVisaSignature(identifier),
-		CommunicationKey: facebook.GetPrivateKeysForDecryption(identifier)[0],
 	}
+	if keys := facebook.GetPrivateKeysForDecryption(identifier); len(keys) > 0 {
+		info.CommunicationKey = keys[0]
+	}
+	return info
 }
 
 func saveInfo(identifier ID, meta Meta, doc Document, idKey SignKey, msgKey DecryptKey) bool {
@@ -92,8 +95,15 @@ func doGenerate(path string, args []string) bool {
 		} else if aType == "group" {
 			founder := IDParse(getOptionString(args, "--founder"))
 			if founder != nil {
+				if SharedFacebook().GetPrivateKeyForVisaSignature(founder) == nil {
+					fmt.Println("!!! founder's private key not found:", founder)
+					return false
+				}
 				info := GenerateGroupInfo(getUserInfo(founder), name, seed)
-				return saveInfo(info.ID, info.Meta, info.Bulletin, nil, nil)
+				if saveInfo(info.ID, info.Meta, info.Bulletin, nil, nil) == false {
+					return false
+				}
+				return SharedFacebook().SaveFounder(founder, info.ID)
 			}
 		} else if aType == "station" {
 			logo := getOptionString(args, "--logo")
@@ -0,0 +1,225 @@
+/* license: https://mit-license.org
+ * ==============================================================================
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2021 Albert Moky
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ * ==============================================================================
+ */
+package main
+
+import (
+	"fmt"
+	. "github.com/dimchat/demo-go/sdk/client"
+	. "github.com/dimchat/mkm-go/protocol"
+)
+
+// copy the bulletin, update a field, then re-sign it with the founder key
+func updateBulletin(group ID, key string, value interface{}) bool {
+	facebook := SharedFacebook()
+	old := facebook.GetDocument(group, BULLETIN)
+	if old == nil {
+		fmt.Println("!!! bulletin not found:", group)
+		return false
+	}
+	founder := getSigner(group)
+	if founder == nil {
+		fmt.Println("!!! founder not found for:", group)
+		return false
+	}
+	signKey := facebook.GetPrivateKeyForVisaSignature(founder)
+	if signKey == nil {
+		fmt.Println("!!! private key not found:", founder)
+		return false
+	}
+	// copy the bulletin, don't touch the cached one
+	info := make(map[string]interface{})
+	for k, v := range old.Map() {
+		info[k] = v
+	}
+	doc := DocumentParse(info)
+	if doc == nil {
+		fmt.Println("!!! bulletin error:", group)
+		return false
+	}
+	doc.SetProperty(key, value)
+	if doc.Sign(signKey) == nil {
+		fmt.Println("!!! failed to sign bulletin:", group)
+		return false
+	}
+	showDifferences(copyProperties(old), copyProperties(doc))
+	if facebook.SaveDocument(doc) == false {
+		fmt.Println("!!! failed to save bulletin:", group)
+		return false
+	}
+	return true
+}
+
+func idStrings(array []ID) []string {
+	texts := make([]string, 0, len(array))
+	for _, item := range array {
+		texts = append(texts, item.String())
+	}
+	return texts
+}
+
+func groupAddMember(group ID, member ID) bool {
+	facebook := SharedFacebook()
+	if len(facebook.GetMembers(group)) == 0 {
+		// founder should be the first member
+		founder := facebook.GetFounder(group)
+		if founder != nil && !founder.Equal(member) {
+			if facebook.AddMember(founder, group) {
+				fmt.Println("  + founder:", founder)
+			}
+		}
+	}
+	if facebook.ContainMember(member, group) {
+		fmt.Println("!!! member already exists:", member)
+		return false
+	}
+	if facebook.AddMember(member, group) == false {
+		fmt.Println("!!! failed to add member:", member)
+		return false
+	}
+	fmt.Println("  + member:", member)
+	return true
+}
+
+func groupRemoveMember(group ID, member ID) bool {
+	facebook := SharedFacebook()
+	founder := facebook.GetFounder(group)
+	if founder != nil && founder.Equal(member) {
+		fmt.Println("!!! cannot remove founder:", member)
+		return false
+	}
+	if facebook.RemoveMember(member, group) == false {
+		fmt.Println("!!! member not found:", member)
+		return false
+	}
+	fmt.Println("  - member:", member)
+	return true
+}
+
+func groupSetOwner(group ID, owner ID) bool {
+	facebook := SharedFacebook()
+	if owner.IsUser() == false {
+		fmt.Println("!!! owner must be a user:", owner)
+		return false
+	}
+	if updateBulletin(group, "owner", owner.String()) == false {
+		return false
+	}
+	if facebook.SaveOwner(owner, group) == false {
+		fmt.Println("!!! failed to save owner:", owner)
+		return false
+	}
+	return true
+}
+
+func groupAddAssistant(group ID, bot ID) bool {
+	facebook := SharedFacebook()
+	if bot.IsUser() == false {
+		fmt.Println("!!! assistant must be a user/robot:", bot)
+		return false
+	}
+	bots := facebook.DB().GetAssistants(group)
+	for _, item := range bots {
+		if bot.Equal(item) {
+			fmt.Println("!!! assistant already exists:", bot)
+			return false
+		}
+	}
+	assistants := make([]ID, 0, len(bots)+1)
+	assistants = append(assistants, bots...)
+	assistants = append(assistants, bot)
+	if updateBulletin(group, "assistants", idStrings(assistants)) == false {
+		return false
+	}
+	if facebook.SaveAssistants(assistants, group) == false {
+		fmt.Println("!!! failed to save assistants:", bot)
+		return false
+	}
+	return true
+}
+
+func groupList(group ID) bool {
+	facebook := SharedFacebook()
+	fmt.Println("******** ID:", group)
+	fmt.Printf("    %-12s %s\n", "name:", getName(group))
+	if founder := facebook.GetFounder(group); founder != nil {
+		fmt.Printf("    %-12s %s\n", "founder:", founder)
+	}
+	if owner := facebook.GetOwner(group); owner != nil {
+		fmt.Printf("    %-12s %s\n", "owner:", owner)
+	}
+	members := facebook.GetMembers(group)
+	fmt.Printf("    %-12s %d\n", "members:", len(members))
+	for _, item := range members {
+		fmt.Printf("        %s (%s)\n", item, getName(item))
+	}
+	bots := facebook.DB().GetAssistants(group)
+	fmt.Printf("    %-12s %d\n", "assistants:", len(bots))
+	for _, item := range bots {
+		fmt.Printf("        %s\n", item)
+	}
+	return true
+}
+
+func doGroup(path string, args []string) bool {
+	if len(args) < 2 {
+		doHelp(path, []string{"group"})
+		return false
+	}
+	cmd := args[0]
+	group := IDParse(args[1])
+	if group == nil || group.IsGroup() == false {
+		fmt.Println("!!! group ID error:", args[1])
+		return false
+	}
+	if SharedFacebook().GetMeta(group) == nil {
+		fmt.Println("!!! group meta not found:", group)
+		return false
+	}
+	if cmd == "list" {
+		return groupList(group)
+	}
+	if len(args) < 3 {
+		doHelp(path, []string{"group"})
+		return false
+	}
+	identifier := IDParse(args[2])
+	if identifier == nil {
+		fmt.Println("!!! ID error:", args[2])
+		return false
+	}
+	fmt.Println("******** ID:", group)
+	if cmd == "add-member" {
+		return groupAddMember(group, identifier)
+	} else if cmd == "remove-member" {
+		return groupRemoveMember(group, identifier)
+	} else if cmd == "set-owner" {
+		return groupSetOwner(group, identifier)
+	} else if cmd == "add-assistant" {
+		return groupAddAssistant(group, identifier)
+	}
+	doHelp(path, []string{"group"})
+	return false
+}
@@ -0,0 +1,95 @@
+/* license: https://mit-license.org
+ * ==============================================================================
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2021 Albert Moky
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ * ==============================================================================
+ */
+package main
+
+import (
+	. "github.com/dimchat/demo-go/sdk/client"
+	. "github.com/dimchat/demo-go/sdk/extensions"
+	. "github.com/dimchat/mkm-go/protocol"
+	"testing"
+)
+
+// generate a user in a temporary root, return its ID
+func prepareUser(t *testing.T, name string) ID {
+	info := GenerateUserInfo(name, "")
+	if saveInfo(info.ID, info.Meta, info.Visa, info.IdentityKey, info.CommunicationKey) == false {
+		t.Fatalf("failed to save user: %s", info.ID)
+	}
+	return info.ID
+}
+
+func findGroup(t *testing.T) ID {
+	for _, item := range getStorage().AllEntities() {
+		if item.IsGroup() {
+			return item
+		}
+	}
+	t.Fatal("group not generated")
+	return nil
+}
+
+func TestGroupSetOwner(t *testing.T) {
+	getStorage().SetRoot(t.TempDir())
+	founder := prepareUser(t, "founder")
+	owner := prepareUser(t, "owner")
+	bot := prepareUser(t, "bot")
+
+	args := []string{"group", "--founder", founder.String(), "--name", "Test Group", "--seed", "test"}
+	if doGenerate("register", args) == false {
+		t.Fatal("failed to generate group")
+	}
+	group := findGroup(t)
+	facebook := SharedFacebook()
+	if !founder.Equal(facebook.GetFounder(group)) {
+		t.Fatalf("founder not saved: %v", facebook.GetFounder(group))
+	}
+
+	if doGroup("register", []string{"set-owner", group.String(), owner.String()}) == false {
+		t.Fatal("set-owner failed")
+	}
+	if !owner.Equal(facebook.GetOwner(group)) {
+		t.Fatalf("owner not saved: %v", facebook.GetOwner(group))
+	}
+	if doGroup("register", []string{"add-assistant", group.String(), bot.String()}) == false {
+		t.Fatal("add-assistant failed")
+	}
+	if doGroup("register", []string{"add-member", group.String(), owner.String()}) == false {
+		t.Fatal("add-member failed")
+	}
+	members := facebook.GetMembers(group)
+	if len(members) != 2 || !founder.Equal(members[0]) {
+		t.Fatalf("founder should be the first member: %v", members)
+	}
+
+	// bulletin re-signed by founder
+	bulletin := facebook.GetDocument(group, BULLETIN)
+	if bulletin == nil || bulletin.Verify(facebook.GetMeta(group).Key()) == false {
+		t.Fatal("bulletin not signed by meta key")
+	}
+	if bulletin.GetProperty("owner") != owner.String() {
+		t.Fatalf("bulletin owner error: %v", bulletin.GetProperty("owner"))
+	}
+}
@@ -64,6 +64,14 @@ func getOptionString(args []string, key string) string {
 	}
 	return ""
 }
+func hasOption(args []string, key string) bool {
+	for _, item := range args {
+		if item == key {
+			return true
+		}
+	}
+	return false
+}
 func getOptionInteger(args []string, key string) int {
 	opt := getOptionString(args, key)
 	num, err := strconv.Atoi(opt)
@@ -77,11 +85,28 @@ func getOptionInteger(args []string, key string) int {
 func showHelp(path string) {
 	fmt.Printf("\n" +
 		"\n    Usages:" +
-		"\n        %s <command> [options]" +
+		"\n        %s [global options] <command> [options]" +
+		"\n" +
+		"\n    Global Options:" +
+		"\n        --root <dir>            Storage root directory (env: DIM_ROOT)." +
+		"\n        --config <file>         Load global options from JSON file," +
+		"\n                                with keys: root, log_level, passphrase_file." +
+		"\n        --log-level <level>     'debug', 'develop' or 'release' (env: DIM_LOG_LEVEL)." +
+		"\n        --passphrase-file <file>" +
+		"\n                                File contains passphrase (env: DIM_PASSPHRASE_FILE)." +
 		"\n" +
 		"\n    Commands:" +
 		"\n        generate                Generate account." +
 		"\n        modify                  Modify account info." +
+		"\n        rotate-key              Rotate communication key for user." +
+		"\n        group                   Manage group members, owner & assistants." +
+		"\n        ans                     Manage ANS aliases." +
+		"\n        passwd                  Change passphrase for private keys." +
+		"\n        list                    List local accounts." +
+		"\n        show                    Show account info." +
+		"\n        export                  Export account to bundle file." +
+		"\n        import                  Import account from bundle file." +
+		"\n        fsck                    Check integrity of local storage." +
 		"\n        help                    Show help for commands." +
 		"\n\n", path)
 }
@@ -100,6 +125,8 @@ func doHelp(path string, args []string) {
 				"\n    Generate Options:" +
 				"\n        --seed <username>       Generate meta with seed string." +
 				"\n        --founder <ID>          Generate group meta with founder ID." +
+				"\n        --no-passphrase         Save private keys without encryption," +
+				"\n                                otherwise a passphrase is required for new storage." +
 				"\n\n", path)
 			return
 		} else if cmd == "modify" {
@@ -116,6 +143,135 @@ func doHelp(path string, args []string) {
 				"\n        --host <IP>             Change IP for station." +
 				"\n        --port <number>         Change port for station." +
 				"\n        --owner <ID>            Change group info with owner ID." +
+				"\n        --rollback <index>      Restore document from history, 0 is the latest" +
+				"\n                                replaced one of the same document type," +
+				"\n                                see 'show --history'." +
+				"\n\n", path)
+			return
+		} else if cmd == "rotate-key" {
+			fmt.Printf("\n" +
+				"\n    Usages:" +
+				"\n        %s rotate-key <ID>" +
+				"\n" +
+				"\n    Descriptions:" +
+				"\n        Generate new communication key for user and update visa," +
+				"\n        old keys are kept for decrypting messages in-flight." +
+				"\n\n", path)
+			return
+		} else if cmd == "group" {
+			fmt.Printf("\n" +
+				"\n    Usages:" +
+				"\n        %s group <sub-command> <group> [ID]" +
+				"\n" +
+				"\n    Descriptions:" +
+				"\n        Manage group info in local storage, the bulletin will be" +
+				"\n        re-signed with founder's private key when owner/assistants changed." +
+				"\n" +
+				"\n    Sub-Commands:" +
+				"\n        add-member <group> <ID>       Add member to group." +
+				"\n        remove-member <group> <ID>    Remove member from group." +
+				"\n        set-owner <group> <ID>        Change group owner." +
+				"\n        add-assistant <group> <ID>    Add assistant bot for group." +
+				"\n        list <group>                  Show founder, owner, members & assistants." +
+				"\n\n", path)
+			return
+		} else if cmd == "ans" {
+			fmt.Printf("\n" +
+				"\n    Usages:" +
+				"\n        %s ans <sub-command> [alias] [ID]" +
+				"\n" +
+				"\n    Descriptions:" +
+				"\n        Manage ANS (Address Name Service) records in local storage," +
+				"\n        reserved names cannot be added or removed:" +
+				"\n            all, everyone, anyone, owner, founder, station, assistant" +
+				"\n" +
+				"\n    Sub-Commands:" +
+				"\n        add <alias> <ID>        Add (or replace) alias for ID." +
+				"\n        remove <alias>          Remove alias." +
+				"\n        list                    List all aliases, reserved ones marked with '*'." +
+				"\n\n", path)
+			return
+		} else if cmd == "passwd" {
+			fmt.Printf("\n" +
+				"\n    Usages:" +
+				"\n        %s passwd" +
+				"\n" +
+				"\n    Descriptions:" +
+				"\n        Re-encrypt all private keys with new passphrase." +
+				"\n" +
+				"\n    Environment Variables:" +
+				"\n        DIM_PASSPHRASE          Current passphrase." +
+				"\n        DIM_PASSPHRASE_FILE     File contains current passphrase." +
+				"\n        DIM_NEW_PASSPHRASE      New passphrase." +
+				"\n\n", path)
+			return
+		} else if cmd == "list" {
+			fmt.Printf("\n" +
+				"\n    Usages:" +
+				"\n        %s list [options]" +
+				"\n" +
+				"\n    Descriptions:" +
+				"\n        List users, groups, stations & robots in local storage," +
+				"\n        with type, name and whether private keys exist." +
+				"\n" +
+				"\n    List Options:" +
+				"\n        --json                  Output in JSON format." +
+				"\n\n", path)
+			return
+		} else if cmd == "show" {
+			fmt.Printf("\n" +
+				"\n    Usages:" +
+				"\n        %s show <ID> [options]" +
+				"\n" +
+				"\n    Descriptions:" +
+				"\n        Show meta, documents, members, ANS aliases and key fingerprints." +
+				"\n" +
+				"\n    Show Options:" +
+				"\n        --json                  Output in JSON format." +
+				"\n        --history               Show previous versions of documents, indexed" +
+				"\n                                for each document type." +
+				"\n        --type <type>           Only show history of the document type," +
+				"\n                                e.g. 'visa', 'profile' or 'bulletin'." +
+				"\n\n", path)
+			return
+		} else if cmd == "export" {
+			fmt.Printf("\n" +
+				"\n    Usages:" +
+				"\n        %s export <ID> --out <file>" +
+				"\n" +
+				"\n    Descriptions:" +
+				"\n        Export meta, visa and private keys of user to a bundle file," +
+				"\n        encrypted with passphrase and signed by identity key." +
+				"\n" +
+				"\n    Environment Variables:" +
+				"\n        DIM_BUNDLE_PASSPHRASE   Passphrase for bundle file." +
+				"\n\n", path)
+			return
+		} else if cmd == "import" {
+			fmt.Printf("\n" +
+				"\n    Usages:" +
+				"\n        %s import <file>" +
+				"\n" +
+				"\n    Descriptions:" +
+				"\n        Import user account from bundle file," +
+				"\n        meta and visa will be verified before saving." +
+				"\n" +
+				"\n    Environment Variables:" +
+				"\n        DIM_BUNDLE_PASSPHRASE   Passphrase for bundle file." +
+				"\n\n", path)
+			return
+		} else if cmd == "fsck" {
+			fmt.Printf("\n" +
+				"\n    Usages:" +
+				"\n        %s fsck [options]" +
+				"\n" +
+				"\n    Descriptions:" +
+				"\n        Check metas, documents, members and private keys in local storage," +
+				"\n        broken files are marked with '!', repaired ones with '*'." +
+				"\n" +
+				"\n    Fsck Options:" +
+				"\n        --repair                Move broken files aside with suffix '.corrupt-{time}'," +
+				"\n                                and rewrite files with valid records only." +
 				"\n\n", path)
 			return
 		}
@@ -130,23 +286,62 @@ func doHelp(path string, args []string) {
 		"\n    Commands:" +
 		"\n        generate" +
 		"\n        modify" +
+		"\n        rotate-key" +
+		"\n        group" +
+		"\n        ans" +
+		"\n        passwd" +
+		"\n        list" +
+		"\n        show" +
+		"\n        export" +
+		"\n        import" +
+		"\n        fsck" +
 		"\n\n", path)
 }
 
 func main() {
 	path := os.Args[0]
-	if len(os.Args) > 1 {
-		cmd := os.Args[1]
-		if cmd == "generate" {
-			doGenerate(path, os.Args[2:])
-			return
-		} else if cmd == "modify" {
-			doModify(path, os.Args[2:])
-			return
-		} else if cmd == "help" {
-			doHelp(path, os.Args[2:])
-			return
-		}
+	opts, args := parseGlobalOptions(os.Args[1:])
+	if opts == nil || !applyGlobalOptions(opts) {
+		os.Exit(1)
+	}
+	if len(args) == 0 {
+		showHelp(path)
+		return
+	}
+	// exit with status 1 when the command failed, so scripts can check it
+	if runCommand(path, args[0], args[1:]) == false {
+		os.Exit(1)
+	}
+}
+
+func runCommand(path string, cmd string, args []string) bool {
+	if cmd == "generate" {
+		return initKeystore(args) && doGenerate(path, args)
+	} else if cmd == "modify" {
+		return unlockKeystore() && doModify(path, args)
+	} else if cmd == "rotate-key" {
+		return unlockKeystore() && doRotateKey(path, args)
+	} else if cmd == "group" {
+		return unlockKeystore() && doGroup(path, args)
+	} else if cmd == "ans" {
+		return doANS(path, args)
+	} else if cmd == "passwd" {
+		return doPasswd(path, args)
+	} else if cmd == "list" {
+		return doList(path, args)
+	} else if cmd == "show" {
+		return doShow(path, args)
+	} else if cmd == "export" {
+		return unlockKeystore() && doExport(path, args)
+	} else if cmd == "import" {
+		return unlockKeystore() && doImport(path, args)
+	} else if cmd == "fsck" {
+		return unlockKeystore() && doFsck(path, args)
+	} else if cmd == "help" {
+		doHelp(path, args)
+		return true
 	}
+	fmt.Println("!!! unknown command:", cmd)
 	showHelp(path)
+	return false
 }
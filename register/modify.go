@@ -25,6 +25,173 @@
  */
 package main
 
-func doModify(path string, args []string) {
+import (
+	"fmt"
+	. "github.com/dimchat/demo-go/sdk/client"
+	. "github.com/dimchat/demo-go/sdk/utils"
+	. "github.com/dimchat/mkm-go/protocol"
+	"sort"
+	"strconv"
+)
 
+func getDocument(identifier ID) Document {
+	facebook := SharedFacebook()
+	if identifier.Type() == STATION {
+		// station info was saved as profile
+		doc := facebook.GetDocument(identifier, PROFILE)
+		if doc != nil {
+			return doc
+		}
+	}
+	return facebook.GetDocument(identifier, "*")
+}
+
+// get the user whose identity key signs the document
+func getSigner(identifier ID) ID {
+	if identifier.IsGroup() {
+		// bulletin must be signed by the group founder (meta key)
+		return SharedFacebook().GetFounder(identifier)
+	}
+	return identifier
+}
+
+func copyProperties(doc Document) map[string]interface{} {
+	properties := make(map[string]interface{})
+	for key, value := range doc.AllProperties() {
+		properties[key] = value
+	}
+	return properties
+}
+
+func showDifferences(oldProps map[string]interface{}, newProps map[string]interface{}) {
+	keys := make([]string, 0, len(newProps))
+	for key := range oldProps {
+		keys = append(keys, key)
+	}
+	for key := range newProps {
+		if _, exists := oldProps[key]; !exists {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if key == "time" {
+			continue
+		}
+		before := fmt.Sprintf("%v", oldProps[key])
+		after := fmt.Sprintf("%v", newProps[key])
+		if before == after {
+			fmt.Printf("    %-12s %s\n", key+":", before)
+		} else {
+			fmt.Printf("  * %-12s %s -> %s\n", key+":", before, after)
+		}
+	}
+}
+
+func doModify(path string, args []string) bool {
+	if len(args) > 0 {
+		identifier := IDParse(args[0])
+		if identifier == nil {
+			fmt.Println("!!! ID error:", args[0])
+			return false
+		}
+		facebook := SharedFacebook()
+		cached := getDocument(identifier)
+		if cached == nil {
+			fmt.Println("!!! document not found:", identifier)
+			return false
+		}
+		if rollback := getOptionString(args, "--rollback"); rollback != "" {
+			return doRollback(identifier, cached, rollback)
+		}
+		// copy the document, don't touch the cached one
+		doc := DocumentParse(CopyMap(cached.Map()))
+		if doc == nil {
+			fmt.Println("!!! document error:", identifier)
+			return false
+		}
+		// arguments
+		name := getOptionString(args, "--name")
+		avatar := getOptionString(args, "--avatar")
+		host := getOptionString(args, "--host")
+		port := getOptionInteger(args, "--port")
+		owner := IDParse(getOptionString(args, "--owner"))
+		// check private key for signature
+		signer := getSigner(identifier)
+		if signer == nil {
+			fmt.Println("!!! signer not found for:", identifier)
+			return false
+		}
+		key := facebook.GetPrivateKeyForVisaSignature(signer)
+		if key == nil {
+			fmt.Println("!!! private key not found:", signer)
+			return false
+		}
+		old := copyProperties(cached)
+		// update document fields
+		if name != "" {
+			doc.SetName(name)
+		}
+		if avatar != "" {
+			visa, ok := doc.(Visa)
+			if ok && visa != nil {
+				visa.SetAvatar(avatar)
+			} else {
+				fmt.Println("!!! avatar is only for user:", identifier)
+				return false
+			}
+		}
+		if host != "" || port > 0 {
+			if identifier.Type() == STATION {
+				if host != "" {
+					doc.SetProperty("host", host)
+				}
+				if port > 0 {
+					doc.SetProperty("port", port)
+				}
+			} else {
+				fmt.Println("!!! host/port are only for station:", identifier)
+				return false
+			}
+		}
+		if owner != nil {
+			if identifier.IsGroup() {
+				doc.SetProperty("owner", owner.String())
+			} else {
+				fmt.Println("!!! owner is only for group:", identifier)
+				return false
+			}
+		}
+		// sign and save
+		if doc.Sign(key) == nil {
+			fmt.Println("!!! failed to sign document:", identifier)
+			return false
+		}
+		fmt.Println("******** ID:", identifier)
+		showDifferences(old, copyProperties(doc))
+		if facebook.SaveDocument(doc) {
+			return true
+		}
+		fmt.Println("!!! failed to save document:", identifier)
+		return false
+	}
+	doHelp(path, []string{"modify"})
+	return false
+}
+
+// restore the document from history
+func doRollback(identifier ID, cached Document, option string) bool {
+	index, err := strconv.Atoi(option)
+	if err != nil || index < 0 {
+		fmt.Println("!!! history index error:", option)
+		return false
+	}
+	facebook := SharedFacebook()
+	if facebook.RollbackDocument(identifier, cached.Type(), index) == false {
+		fmt.Println("!!! failed to rollback document:", identifier, cached.Type(), index)
+		return false
+	}
+	fmt.Println("******** ID:", identifier)
+	showDifferences(copyProperties(cached), copyProperties(getDocument(identifier)))
+	return true
 }
@@ -0,0 +1,166 @@
+/* license: https://mit-license.org
+ * ==============================================================================
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2021 Albert Moky
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ * ==============================================================================
+ */
+package main
+
+import (
+	"bufio"
+	"fmt"
+	. "github.com/dimchat/demo-go/sdk/database"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// switch terminal echo, ignore errors when stdin is not a terminal
+func setEcho(on bool) {
+	flag := "-echo"
+	if on {
+		flag = "echo"
+	}
+	cmd := exec.Command("stty", flag)
+	cmd.Stdin = os.Stdin
+	_ = cmd.Run()
+}
+
+func readPassphrase(prompt string) string {
+	fmt.Fprint(os.Stderr, prompt)
+	setEcho(false)
+	defer setEcho(true)
+	reader := bufio.NewReader(os.Stdin)
+	text, _ := reader.ReadString('\n')
+	fmt.Fprintln(os.Stderr)
+	return strings.TrimRight(text, "\r\n")
+}
+
+/**
+ *  Get passphrase from:
+ *      1. environment variable 'DIM_PASSPHRASE'
+ *      2. file specified by environment variable 'DIM_PASSPHRASE_FILE'
+ *      3. terminal input
+ */
+func getPassphrase(prompt string) string {
+	password := os.Getenv("DIM_PASSPHRASE")
+	if password != "" {
+		return password
+	}
+	path := os.Getenv("DIM_PASSPHRASE_FILE")
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err == nil {
+			return strings.TrimRight(string(data), "\r\n")
+		}
+		fmt.Println("!!! failed to read passphrase file:", path)
+	}
+	return readPassphrase(prompt)
+}
+
+func getKeystore() Keystore {
+	keystore, _ := SharedDatabase().(Keystore)
+	return keystore
+}
+
+// unlock keystore before reading/writing private keys
+func unlockKeystore() bool {
+	keystore := getKeystore()
+	if keystore == nil {
+		return true
+	}
+	if keystore.IsEncrypted() == false {
+		fmt.Println("!!! private keys are NOT encrypted, run 'passwd' command to set a passphrase")
+		return true
+	}
+	if keystore.SetPassword(getPassphrase("Passphrase: ")) {
+		return true
+	}
+	fmt.Println("!!! wrong passphrase")
+	return false
+}
+
+/**
+ *  Create keystore before generating the first account,
+ *  so private keys will be encrypted from the very beginning
+ *
+ *  options:
+ *      --no-passphrase     save private keys without encryption
+ */
+func initKeystore(args []string) bool {
+	keystore := getKeystore()
+	if keystore == nil || keystore.IsEncrypted() {
+		return unlockKeystore()
+	}
+	if hasOption(args, "--no-passphrase") {
+		fmt.Println("!!! private keys will NOT be encrypted")
+		return true
+	}
+	password := os.Getenv("DIM_PASSPHRASE")
+	if password == "" {
+		password = getPassphrase("New passphrase: ")
+		if os.Getenv("DIM_PASSPHRASE_FILE") == "" && readPassphrase("Confirm new passphrase: ") != password {
+			fmt.Println("!!! passphrases not match")
+			return false
+		}
+	}
+	if password == "" {
+		fmt.Println("!!! empty passphrase, use '--no-passphrase' to save private keys without encryption")
+		return false
+	}
+	if keystore.SetPassword(password) {
+		return true
+	}
+	fmt.Println("!!! failed to create keystore, if plain private keys exist," +
+		" run 'passwd' command to encrypt them, or use '--no-passphrase' to continue")
+	return false
+}
+
+func doPasswd(path string, args []string) bool {
+	if len(args) > 0 {
+		doHelp(path, []string{"passwd"})
+		return false
+	}
+	keystore := getKeystore()
+	if keystore == nil {
+		fmt.Println("!!! keystore not supported by this database")
+		return false
+	}
+	if unlockKeystore() == false {
+		return false
+	}
+	// new passphrase
+	password := os.Getenv("DIM_NEW_PASSPHRASE")
+	if password == "" {
+		password = readPassphrase("New passphrase (empty for no encryption): ")
+		if readPassphrase("Confirm new passphrase: ") != password {
+			fmt.Println("!!! passphrases not match")
+			return false
+		}
+	}
+	if keystore.ResetPassword(password) {
+		fmt.Println("******** all private keys re-encrypted")
+		return true
+	}
+	fmt.Println("!!! failed to re-encrypt private keys")
+	return false
+}
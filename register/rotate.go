@@ -0,0 +1,59 @@
+/* license: https://mit-license.org
+ * ==============================================================================
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2021 Albert Moky
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ * ==============================================================================
+ */
+package main
+
+import (
+	"fmt"
+	. "github.com/dimchat/demo-go/sdk/client"
+	. "github.com/dimchat/mkm-go/protocol"
+)
+
+func doRotateKey(path string, args []string) bool {
+	if len(args) == 0 {
+		doHelp(path, []string{"rotate-key"})
+		return false
+	}
+	identifier := IDParse(args[0])
+	if identifier == nil || identifier.IsUser() == false {
+		fmt.Println("!!! user ID error:", args[0])
+		return false
+	}
+	facebook := SharedFacebook()
+	old, ok := facebook.GetDocument(identifier, VISA).(Visa)
+	if !ok || old == nil {
+		fmt.Println("!!! visa not found:", identifier)
+		return false
+	}
+	oldKey := getFingerprint(old.Key())
+	key := facebook.RotateCommunicationKey(identifier)
+	if key == nil {
+		fmt.Println("!!! failed to rotate communication key:", identifier)
+		return false
+	}
+	fmt.Println("******** ID:", identifier)
+	fmt.Printf("  * %-12s %s -> %s\n", "visa key:", oldKey, getFingerprint(key.PublicKey()))
+	return true
+}
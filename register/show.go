@@ -0,0 +1,280 @@
+/* license: https://mit-license.org
+ * ==============================================================================
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2021 Albert Moky
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ * ==============================================================================
+ */
+package main
+
+import (
+	"fmt"
+	. "github.com/dimchat/demo-go/sdk/database"
+	. "github.com/dimchat/mkm-go/crypto"
+	. "github.com/dimchat/mkm-go/digest"
+	. "github.com/dimchat/mkm-go/format"
+	. "github.com/dimchat/mkm-go/protocol"
+	"sort"
+	"strings"
+)
+
+func getStorage() *Storage {
+	storage, _ := SharedDatabase().(*Storage)
+	return storage
+}
+
+func getTypeName(identifier ID) string {
+	switch identifier.Type() {
+	case ROBOT:
+		return "robot"
+	case STATION:
+		return "station"
+	case PROVIDER:
+		return "provider"
+	}
+	if identifier.IsGroup() {
+		return "group"
+	} else if identifier.IsUser() {
+		return "user"
+	}
+	return "unknown"
+}
+
+// get all documents of the entity, different types may be stored in the same file
+func getDocuments(identifier ID) []Document {
+	db := SharedDatabase()
+	array := make([]Document, 0, 1)
+	for _, docType := range []string{VISA, PROFILE, BULLETIN} {
+		doc := db.GetDocument(identifier, docType)
+		if doc == nil {
+			continue
+		}
+		duplicated := false
+		for _, item := range array {
+			if item.Get("signature") == doc.Get("signature") {
+				duplicated = true
+				break
+			}
+		}
+		if !duplicated {
+			array = append(array, doc)
+		}
+	}
+	return array
+}
+
+func getName(identifier ID) string {
+	for _, doc := range getDocuments(identifier) {
+		name := doc.Name()
+		if name != "" {
+			return name
+		}
+	}
+	return identifier.Name()
+}
+
+// short fingerprint of public key: first 8 bytes of SHA-256(key.data)
+func getFingerprint(key CryptographyKey) string {
+	if key == nil {
+		return ""
+	}
+	hex := HexEncode(SHA256(key.Data()))
+	pairs := make([]string, 0, 8)
+	for pos := 0; pos < 16; pos += 2 {
+		pairs = append(pairs, hex[pos:pos+2])
+	}
+	return strings.Join(pairs, ":")
+}
+
+func getAliases(identifier ID) []string {
+	aliases := make([]string, 0)
+	for alias, item := range SharedDatabase().AllRecords() {
+		if identifier.Equal(item) {
+			aliases = append(aliases, alias)
+		}
+	}
+	sort.Strings(aliases)
+	return aliases
+}
+
+func doList(path string, args []string) bool {
+	storage := getStorage()
+	if storage == nil {
+		fmt.Println("!!! listing entities is only supported by local storage")
+		return false
+	}
+	asJSON := hasOption(args, "--json")
+	array := storage.AllEntities()
+	// users first, then groups, stations & robots
+	order := []string{"user", "group", "station", "provider", "robot", "unknown"}
+	rank := func(identifier ID) int {
+		name := getTypeName(identifier)
+		for index, item := range order {
+			if item == name {
+				return index
+			}
+		}
+		return len(order)
+	}
+	sort.SliceStable(array, func(i, j int) bool {
+		return rank(array[i]) < rank(array[j])
+	})
+	list := make([]interface{}, 0, len(array))
+	if !asJSON {
+		fmt.Printf("%-10s %-5s %-50s %s\n", "TYPE", "KEYS", "ID", "NAME")
+	}
+	for _, identifier := range array {
+		typeName := getTypeName(identifier)
+		name := getName(identifier)
+		keys := storage.HasPrivateKey(identifier)
+		if asJSON {
+			list = append(list, map[string]interface{}{
+				"ID": identifier.String(),
+				"type": typeName,
+				"name": name,
+				"private_key": keys,
+			})
+			continue
+		}
+		mark := "-"
+		if keys {
+			mark = "yes"
+		}
+		fmt.Printf("%-10s %-5s %-50s %s\n", typeName, mark, identifier.String(), name)
+	}
+	if asJSON {
+		fmt.Println(JSONEncodeList(list))
+	}
+	return true
+}
+
+func doShow(path string, args []string) bool {
+	if len(args) == 0 {
+		doHelp(path, []string{"show"})
+		return false
+	}
+	identifier := IDParse(args[0])
+	if identifier == nil {
+		fmt.Println("!!! ID error:", args[0])
+		return false
+	}
+	db := SharedDatabase()
+	meta := db.GetMeta(identifier)
+	if meta == nil {
+		fmt.Println("!!! meta not found:", identifier)
+		return false
+	}
+	info := make(map[string]interface{})
+	info["ID"] = identifier.String()
+	info["type"] = getTypeName(identifier)
+	info["name"] = getName(identifier)
+	info["meta"] = meta.Map()
+	// documents
+	docs := getDocuments(identifier)
+	array := make([]interface{}, 0, len(docs))
+	keys := make(map[string]interface{})
+	keys["meta"] = getFingerprint(meta.Key())
+	for _, doc := range docs {
+		array = append(array, doc.Map())
+		if visa, ok := doc.(Visa); ok && visa.Key() != nil {
+			keys["visa"] = getFingerprint(visa.Key())
+		}
+	}
+	info["documents"] = array
+	// previous versions of each document type, latest first,
+	// the index is the same as 'modify --rollback <index>' for this type
+	historyTypes := make([]string, 0, len(docs))
+	history := make(map[string][]Document, len(docs))
+	if hasOption(args, "--history") {
+		filter := getOptionString(args, "--type")
+		for _, doc := range docs {
+			docType := doc.Type()
+			if _, exists := history[docType]; exists || (filter != "" && filter != docType) {
+				continue
+			}
+			historyTypes = append(historyTypes, docType)
+			history[docType] = db.GetDocumentHistory(identifier, docType)
+		}
+		lists := make(map[string]interface{}, len(history))
+		for docType, array := range history {
+			list := make([]interface{}, 0, len(array))
+			for _, doc := range array {
+				list = append(list, doc.Map())
+			}
+			lists[docType] = list
+		}
+		info["history"] = lists
+	}
+	// group info
+	if identifier.IsGroup() {
+		if founder := db.GetFounder(identifier); founder != nil {
+			info["founder"] = founder.String()
+		}
+		if owner := db.GetOwner(identifier); owner != nil {
+			info["owner"] = owner.String()
+		}
+		info["members"] = IDRevert(db.GetMembers(identifier))
+		info["assistants"] = IDRevert(db.GetAssistants(identifier))
+	}
+	info["aliases"] = getAliases(identifier)
+	if storage := getStorage(); storage != nil {
+		keys["private"] = storage.HasPrivateKey(identifier)
+	}
+	info["keys"] = keys
+	if hasOption(args, "--json") {
+		fmt.Println(JSONEncodeMap(info))
+		return true
+	}
+	// text mode
+	fmt.Println("******** ID:", identifier)
+	fmt.Println("    type:", info["type"])
+	fmt.Println("    name:", info["name"])
+	fmt.Println("    aliases:", strings.Join(info["aliases"].([]string), ", "))
+	fmt.Println("******** keys:")
+	for _, name := range []string{"meta", "visa", "private"} {
+		if value, ok := keys[name]; ok {
+			fmt.Printf("    %-8s %v\n", name+":", value)
+		}
+	}
+	if identifier.IsGroup() {
+		fmt.Println("******** group:")
+		fmt.Println("    founder:", info["founder"])
+		fmt.Println("    owner:", info["owner"])
+		fmt.Println("    assistants:", strings.Join(info["assistants"].([]string), ", "))
+		fmt.Println("    members:")
+		for _, member := range info["members"].([]string) {
+			fmt.Println("        " + member)
+		}
+	}
+	fmt.Println("******** meta:")
+	fmt.Println(JSONEncodeMap(meta.Map()))
+	for _, doc := range docs {
+		fmt.Println("******** document:")
+		fmt.Println(JSONEncodeMap(doc.Map()))
+	}
+	for _, docType := range historyTypes {
+		for index, doc := range history[docType] {
+			fmt.Printf("******** %s history [%d] %s:\n", docType, index, doc.Time().Format("2006-01-02 15:04:05"))
+			fmt.Println(JSONEncodeMap(doc.Map()))
+		}
+	}
+	return true
+}
@@ -28,6 +28,7 @@ package dimp
 import (
 	. "github.com/dimchat/demo-go/sdk/common"
 	. "github.com/dimchat/demo-go/sdk/database"
+	"sync"
 )
 
 type IClientFacebook interface {
@@ -52,14 +53,32 @@ func (facebook *ClientFacebook) Init() *ClientFacebook {
 //  Singleton
 //
 var sharedFacebook *ClientFacebook
+var sharedFacebookOnce sync.Once
 
 func SharedFacebook() IClientFacebook {
+	sharedFacebookOnce.Do(func() {
+		sharedFacebook = new(ClientFacebook)
+		sharedFacebook.Init()
+		sharedFacebook.SetSource(sharedFacebook)
+		sharedFacebook.SetDB(SharedDatabase())
+		SharedAddressNameService().SetTable(SharedDatabase())
+	})
 	return sharedFacebook
 }
 
-func init() {
-	sharedFacebook = new(ClientFacebook)
-	sharedFacebook.Init()
-	sharedFacebook.SetSource(sharedFacebook)
-	sharedFacebook.SetDB(SharedDatabase())
+/**
+ *  Replace the database for client before first use,
+ *  e.g. a memory storage for unit tests
+ */
+func ClientFacebookSetDatabase(db Database) {
+	SetSharedDatabase(db)
+	SharedFacebook().SetDB(db)
+	SharedAddressNameService().SetTable(db)
+	// update key table for messenger
+	if sharedMessenger != nil {
+		cache, ok := sharedMessenger.CipherKeyDelegate().(*KeyCache)
+		if ok && cache != nil {
+			cache.SetKeyTable(db)
+		}
+	}
 }
@@ -29,13 +29,16 @@ import (
 	. "github.com/dimchat/core-go/dimp"
 	. "github.com/dimchat/demo-go/sdk/client/cpu"
 	. "github.com/dimchat/demo-go/sdk/common"
+	. "github.com/dimchat/demo-go/sdk/database"
 	. "github.com/dimchat/sdk-go/dimp"
 	. "github.com/dimchat/sdk-go/dimp/cpu"
+	"sync"
 )
 
 func createKeyCache() CipherKeyDelegate {
 	cache := new(KeyCache)
 	cache.Init()
+	cache.SetKeyTable(SharedDatabase())
 	return cache
 }
 func createProcessor(facebook IClientFacebook, messenger IClientMessenger) Processor {
@@ -87,12 +90,12 @@ func (messenger *ClientMessenger) Init(facebook IClientFacebook) *ClientMessenge
 //  Singleton
 //
 var sharedMessenger *ClientMessenger
+var sharedMessengerOnce sync.Once
 
 func SharedMessenger() IClientMessenger {
+	sharedMessengerOnce.Do(func() {
+		sharedMessenger = new(ClientMessenger)
+		sharedMessenger.Init(SharedFacebook())
+	})
 	return sharedMessenger
 }
-
-func init() {
-	sharedMessenger = new(ClientMessenger)
-	sharedMessenger.Init(SharedFacebook())
-}
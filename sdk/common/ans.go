@@ -49,6 +49,10 @@ func (ans *AddressNameDataSource) Init() *AddressNameDataSource {
 	return ans
 }
 
+func (ans *AddressNameDataSource) SetTable(table AddressNameTable) {
+	ans._ansTable = table
+}
+
 func (ans *AddressNameDataSource) GetID(alias string) ID {
 	identifier := ans.AddressNameServer.GetID(alias)
 	if identifier == nil && ans._ansTable != nil {
@@ -62,8 +66,13 @@ func (ans *AddressNameDataSource) GetID(alias string) ID {
 }
 
 func (ans *AddressNameDataSource) Save(alias string, identifier ID) bool {
-	if ans.AddressNameServer.Save(alias, identifier) == false {
+	if IsReservedName(alias) {
+		// reserved names cannot be shadowed
+		return false
+	} else if ans.AddressNameServer.Save(alias, identifier) == false {
 		return false
+	} else if ans._ansTable == nil {
+		return true
 	} else if ValueIsNil(identifier) {
 		return ans._ansTable.RemoveRecord(alias)
 	} else {
@@ -106,10 +115,17 @@ func (factory *CommonIDFactory) ParseID(identifier string) ID {
 	return id
 }
 
+var sharedANS *AddressNameDataSource
+
+func SharedAddressNameService() *AddressNameDataSource {
+	return sharedANS
+}
+
 func UpgradeIDFactory() {
 	// ANS
 	ans := new(AddressNameDataSource)
 	ans.Init()
+	sharedANS = ans
 
 	// origin ID factory
 	origin := IDGetFactory()
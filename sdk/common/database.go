@@ -90,6 +90,10 @@ func (db *FacebookDatabase) SavePrivateKey(user ID, key PrivateKey, keyType stri
 	return db._privateTable.SavePrivateKey(user, key, keyType, sign, decrypt)
 }
 
+func (db *FacebookDatabase) RemovePrivateKey(user ID, key PrivateKey, keyType string) bool {
+	return db._privateTable.RemovePrivateKey(user, key, keyType)
+}
+
 func (db *FacebookDatabase) GetPrivateKeysForDecryption(user ID) []DecryptKey {
 	return db._privateTable.GetPrivateKeysForDecryption(user)
 }
@@ -122,6 +126,10 @@ func (db *FacebookDatabase) GetDocument(entity ID, docType string) Document {
 	return db._docTable.GetDocument(entity, docType)
 }
 
+func (db *FacebookDatabase) GetDocumentHistory(entity ID, docType string) []Document {
+	return db._docTable.GetDocumentHistory(entity, docType)
+}
+
 //-------- UserTable
 
 func (db *FacebookDatabase) AllUsers() []ID {
@@ -151,7 +159,7 @@ func (db *FacebookDatabase) GetFounder(group ID) ID {
 }
 
 func (db *FacebookDatabase) GetOwner(group ID) ID {
-	return db._groupTable.GetFounder(group)
+	return db._groupTable.GetOwner(group)
 }
 
 func (db *FacebookDatabase) GetMembers(group ID) []ID {
@@ -174,6 +182,18 @@ func (db *FacebookDatabase) SaveMembers(members []ID, group ID) bool {
 	return db._groupTable.SaveMembers(members, group)
 }
 
+func (db *FacebookDatabase) SaveFounder(founder ID, group ID) bool {
+	return db._groupTable.SaveFounder(founder, group)
+}
+
+func (db *FacebookDatabase) SaveOwner(owner ID, group ID) bool {
+	return db._groupTable.SaveOwner(owner, group)
+}
+
+func (db *FacebookDatabase) SaveAssistants(bots []ID, group ID) bool {
+	return db._groupTable.SaveAssistants(bots, group)
+}
+
 func (db *FacebookDatabase) RemoveGroup(group ID) bool {
 	return db._groupTable.RemoveGroup(group)
 }
@@ -33,6 +33,23 @@ const (
 	AnyAssistant = "assistant@anywhere"  // Group manager
 )
 
+/**
+ *  Names which cannot be used as ANS alias
+ */
+var ReservedNames = []string{
+	"all", "everyone", "anyone", "owner", "founder",
+	"station", "assistant",
+}
+
+func IsReservedName(alias string) bool {
+	for _, name := range ReservedNames {
+		if alias == name {
+			return true
+		}
+	}
+	return false
+}
+
 type AddressNameTable interface {
 
 	/**
@@ -59,4 +76,11 @@ type AddressNameTable interface {
 	 * @return true on success
 	 */
 	RemoveRecord(alias string) bool
+
+	/**
+	 *  Get all ANS records
+	 *
+	 * @return short name -> user ID
+	 */
+	AllRecords() map[string]ID
 }
\ No newline at end of file
@@ -32,4 +32,13 @@ type DocumentTable interface {
 	SaveDocument(doc Document) bool
 
 	GetDocument(entity ID, docType string) Document
+
+	/**
+	 *  Get previous versions of the document
+	 *
+	 * @param entity  - entity ID
+	 * @param docType - document type
+	 * @return replaced documents, latest first
+	 */
+	GetDocumentHistory(entity ID, docType string) []Document
 }
@@ -43,5 +43,11 @@ type GroupTable interface {
 
 	SaveMembers(members []ID, group ID) bool
 
+	SaveFounder(founder ID, group ID) bool
+
+	SaveOwner(owner ID, group ID) bool
+
+	SaveAssistants(bots []ID, group ID) bool
+
 	RemoveGroup(group ID) bool
 }
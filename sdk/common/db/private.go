@@ -49,6 +49,16 @@ type PrivateKeyTable interface {
 	 */
 	SavePrivateKey(user ID, key PrivateKey, keyType string, sign bool, decrypt bool) bool
 
+	/**
+	 *  Remove communication key for user, e.g. a new key not published
+	 *
+	 * @param user - user ID
+	 * @param key - private key
+	 * @param type - only 'V' for communication keys, identity key won't change
+	 * @return false on error or not found
+	 */
+	RemovePrivateKey(user ID, key PrivateKey, keyType string) bool
+
 	/**
 	 *  Get private keys for user
 	 *
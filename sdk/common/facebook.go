@@ -27,7 +27,9 @@ package dimp
 
 import (
 	. "github.com/dimchat/core-go/mkm"
+	. "github.com/dimchat/demo-go/sdk/common/db"
 	. "github.com/dimchat/demo-go/sdk/extensions"
+	. "github.com/dimchat/demo-go/sdk/utils"
 	. "github.com/dimchat/mkm-go/crypto"
 	. "github.com/dimchat/mkm-go/protocol"
 	. "github.com/dimchat/mkm-go/types"
@@ -37,6 +39,8 @@ import (
 type IFacebookExtension interface {
 
 	SavePrivateKey(key PrivateKey, keyType string, user ID) bool
+	RotateCommunicationKey(user ID) PrivateKey
+	RollbackDocument(identifier ID, docType string, index int) bool
 
 	SetCurrentUser(user User)
 	AddUser(user User) bool
@@ -47,6 +51,9 @@ type IFacebookExtension interface {
 
 	AddMember(member ID, group ID) bool
 	RemoveMember(member ID, group ID) bool
+	SaveFounder(founder ID, group ID) bool
+	SaveOwner(owner ID, group ID) bool
+	SaveAssistants(bots []ID, group ID) bool
 	ContainMember(member ID, group ID) bool
 	ContainAssistant(bot ID, group ID) bool
 	RemoveGroup(group ID) bool
@@ -97,6 +104,7 @@ func (facebook *CommonFacebook) self() ICommonFacebook {
 
 func (facebook *CommonFacebook) SetDB(db IFacebookDatabase) {
 	facebook._db = db
+	facebook._users = nil
 }
 func (facebook *CommonFacebook) DB() IFacebookDatabase {
 	return facebook._db
@@ -171,6 +179,100 @@ func (facebook *CommonFacebook) SavePrivateKey(key PrivateKey, keyType string, u
 	return facebook.DB().SavePrivateKey(user, key, keyType, true, ok)
 }
 
+/**
+ *  Generate a new communication key for the user, set it on the visa
+ *  and re-sign it with the identity key;
+ *  old keys are kept for decrypting messages in-flight
+ *
+ * @param user - local user ID
+ * @return new private key, nil on failed
+ */
+func (facebook *CommonFacebook) RotateCommunicationKey(user ID) PrivateKey {
+	self := facebook.self()
+	// 1. get visa & identity key
+	old, ok := self.GetDocument(user, VISA).(Visa)
+	if !ok || old == nil {
+		return nil
+	}
+	signKey := self.GetPrivateKeyForVisaSignature(user)
+	if signKey == nil {
+		return nil
+	}
+	// copy the visa, don't touch the cached one
+	visa, ok := DocumentParse(CopyMap(old.Map())).(Visa)
+	if !ok || visa == nil {
+		return nil
+	}
+	// 2. generate new key
+	msgKey := PrivateKeyGenerate(RSA)
+	visaKey, ok := msgKey.PublicKey().(EncryptKey)
+	if !ok || visaKey == nil {
+		return nil
+	}
+	// 3. update visa
+	visa.SetKey(visaKey)
+	if visa.Sign(signKey) == nil {
+		return nil
+	}
+	// 4. save new key for decryption before publishing the visa,
+	//    so messages encrypted with the new visa key can always be decrypted;
+	//    the current key is still used for signature
+	db := facebook.DB()
+	if db.SavePrivateKey(user, msgKey, VISA_KEY, false, true) == false {
+		return nil
+	}
+	// 5. publish the new visa, drop the new key if failed
+	if self.SaveDocument(visa) == false {
+		db.RemovePrivateKey(user, msgKey, VISA_KEY)
+		return nil
+	}
+	// 6. sign messages with the new key
+	if self.SavePrivateKey(msgKey, VISA_KEY, user) == false {
+		return nil
+	}
+	return msgKey
+}
+
+/**
+ *  Restore a previous version of the document from history,
+ *  re-sign it with current time, so it can replace the newer one
+ *
+ * @param identifier - entity ID
+ * @param docType    - document type
+ * @param index      - position in history, 0 is the latest replaced one
+ * @return false on history not found or signing failed
+ */
+func (facebook *CommonFacebook) RollbackDocument(identifier ID, docType string, index int) bool {
+	self := facebook.self()
+	history := facebook.DB().GetDocumentHistory(identifier, docType)
+	if index < 0 || index >= len(history) {
+		return false
+	}
+	// copy the document, don't touch the stored one
+	doc := DocumentParse(CopyMap(history[index].Map()))
+	if doc == nil {
+		return false
+	}
+	// bulletin must be signed by the group founder (meta key)
+	signer := identifier
+	if identifier.IsGroup() {
+		signer = self.GetFounder(identifier)
+		if signer == nil {
+			return false
+		}
+	}
+	signKey := self.GetPrivateKeyForVisaSignature(signer)
+	if signKey == nil {
+		return false
+	}
+	// update time & sign
+	doc.SetProperty("time", Timestamp(TimeNow()))
+	if doc.Sign(signKey) == nil {
+		return false
+	}
+	return self.SaveDocument(doc)
+}
+
 //-------- Local Users
 
 func (facebook *CommonFacebook) SetCurrentUser(user User) {
@@ -206,6 +308,15 @@ func (facebook *CommonFacebook) AddMember(member ID, group ID) bool {
 func (facebook *CommonFacebook) RemoveMember(member ID, group ID) bool {
 	return facebook.DB().RemoveMember(member, group)
 }
+func (facebook *CommonFacebook) SaveFounder(founder ID, group ID) bool {
+	return facebook.DB().SaveFounder(founder, group)
+}
+func (facebook *CommonFacebook) SaveOwner(owner ID, group ID) bool {
+	return facebook.DB().SaveOwner(owner, group)
+}
+func (facebook *CommonFacebook) SaveAssistants(bots []ID, group ID) bool {
+	return facebook.DB().SaveAssistants(bots, group)
+}
 func (facebook *CommonFacebook) ContainMember(member ID, group ID) bool {
 	members := facebook.self().GetMembers(group)
 	if members != nil {
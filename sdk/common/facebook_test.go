@@ -0,0 +1,142 @@
+/* license: https://mit-license.org
+ * ==============================================================================
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2021 Albert Moky
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ * ==============================================================================
+ */
+package dimp
+
+import (
+	. "github.com/dimchat/demo-go/sdk/common/db"
+	. "github.com/dimchat/demo-go/sdk/database"
+	. "github.com/dimchat/demo-go/sdk/extensions"
+	. "github.com/dimchat/mkm-go/crypto"
+	. "github.com/dimchat/mkm-go/protocol"
+	"testing"
+)
+
+/**
+ *  Memory storage with failures
+ */
+type testDatabase struct {
+	*MemoryStorage
+
+	_failKey bool
+	_failDocument bool
+}
+
+func (db *testDatabase) SavePrivateKey(user ID, key PrivateKey, keyType string, sign bool, decrypt bool) bool {
+	if db._failKey {
+		return false
+	}
+	return db.MemoryStorage.SavePrivateKey(user, key, keyType, sign, decrypt)
+}
+
+func (db *testDatabase) SaveDocument(doc Document) bool {
+	if db._failDocument {
+		return false
+	}
+	return db.MemoryStorage.SaveDocument(doc)
+}
+
+func prepareFacebook(t *testing.T) (*CommonFacebook, *testDatabase, *UserInfo) {
+	db := &testDatabase{MemoryStorage: NewMemoryStorage()}
+	facebook := new(CommonFacebook).Init()
+	facebook.SetSource(facebook)
+	facebook.SetDB(db)
+	info := GenerateUserInfo("user", "")
+	if db.SaveMeta(info.Meta, info.ID) == false ||
+		db.SavePrivateKey(info.ID, info.IdentityKey.(PrivateKey), META_KEY, true, false) == false ||
+		db.SavePrivateKey(info.ID, info.CommunicationKey.(PrivateKey), VISA_KEY, true, true) == false ||
+		db.SaveDocument(info.Visa) == false {
+		t.Fatalf("failed to save user: %s", info.ID)
+	}
+	return facebook, db, info
+}
+
+func visaKeyData(db *testDatabase, user ID) interface{} {
+	visa, _ := db.GetDocument(user, VISA).(Visa)
+	if visa == nil || visa.Key() == nil {
+		return nil
+	}
+	return visa.Key().Get("data")
+}
+
+func hasDecryptionKey(db *testDatabase, user ID, key PrivateKey) bool {
+	for _, item := range db.GetPrivateKeysForDecryption(user) {
+		if item.Get("data") == key.Get("data") {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRotateCommunicationKey(t *testing.T) {
+	facebook, db, info := prepareFacebook(t)
+	msgKey := facebook.RotateCommunicationKey(info.ID)
+	if msgKey == nil {
+		t.Fatal("failed to rotate communication key")
+	}
+	if visaKeyData(db, info.ID) != msgKey.PublicKey().Get("data") {
+		t.Fatal("visa key not updated")
+	}
+	if !msgKey.Equal(db.GetPrivateKeyForSignature(info.ID)) {
+		t.Fatal("new key not used for signature")
+	}
+	// old key kept for messages in-flight
+	if !hasDecryptionKey(db, info.ID, info.CommunicationKey.(PrivateKey)) {
+		t.Fatal("old key dropped")
+	}
+}
+
+func TestRotateCommunicationKeyVisaFailed(t *testing.T) {
+	facebook, db, info := prepareFacebook(t)
+	oldData := visaKeyData(db, info.ID)
+	decryptionKeys := len(db.GetPrivateKeysForDecryption(info.ID))
+	db._failDocument = true
+	if facebook.RotateCommunicationKey(info.ID) != nil {
+		t.Fatal("rotation should fail without saving visa")
+	}
+	if visaKeyData(db, info.ID) != oldData {
+		t.Fatal("visa should not change")
+	}
+	// the new key dropped, old key still for signature
+	if !info.CommunicationKey.(PrivateKey).Equal(db.GetPrivateKeyForSignature(info.ID)) {
+		t.Fatal("old key not used for signature")
+	}
+	if len(db.GetPrivateKeysForDecryption(info.ID)) != decryptionKeys {
+		t.Fatal("new key not removed")
+	}
+}
+
+func TestRotateCommunicationKeyKeyFailed(t *testing.T) {
+	facebook, db, info := prepareFacebook(t)
+	oldData := visaKeyData(db, info.ID)
+	db._failKey = true
+	if facebook.RotateCommunicationKey(info.ID) != nil {
+		t.Fatal("rotation should fail without saving key")
+	}
+	// no visa published for a key not saved
+	if visaKeyData(db, info.ID) != oldData {
+		t.Fatal("visa should not change")
+	}
+}
@@ -26,6 +26,7 @@
 package dimp
 
 import (
+	"bytes"
 	. "github.com/dimchat/mkm-go/crypto"
 	. "github.com/dimchat/mkm-go/protocol"
 )
@@ -68,10 +69,29 @@ func (facebook *CommonFacebook) GetFounder(group ID) ID {
 	founder := facebook.DB().GetFounder(group)
 	if founder == nil {
 		founder = facebook.Facebook.GetFounder(group)
+		if founder == nil {
+			founder = facebook.localFounder(group)
+		}
 	}
 	return founder
 }
 
+// the local user whose meta key generated the group meta
+func (facebook *CommonFacebook) localFounder(group ID) ID {
+	meta := facebook.self().GetMeta(group)
+	if meta == nil || meta.Key() == nil {
+		return nil
+	}
+	data := meta.Key().Data()
+	for _, user := range facebook.DB().AllUsers() {
+		userMeta := facebook.self().GetMeta(user)
+		if userMeta != nil && bytes.Equal(userMeta.Key().Data(), data) {
+			return user
+		}
+	}
+	return nil
+}
+
 func (facebook *CommonFacebook) GetOwner(group ID) ID {
 	owner := facebook.DB().GetOwner(group)
 	if owner == nil {
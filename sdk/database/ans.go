@@ -35,6 +35,8 @@ import (
 //-------- AddressNameTable
 
 func (db *Storage) GetIdentifier(alias string) ID {
+	db._ansLock.RLock()
+	defer db._ansLock.RUnlock()
 	return db._ans[alias]
 }
 
@@ -42,8 +44,10 @@ func (db *Storage) AddRecord(identifier ID, alias string) bool {
 	if len(alias) == 0 || ValueIsNil(identifier) {
 		return false
 	}
-	if len(db._ans) == 0 {
-		panic("ANS not initialized")
+	db._ansLock.Lock()
+	defer db._ansLock.Unlock()
+	if db._ans == nil {
+		db._ans = reserveANS(make(map[string]ID))
 	}
 	// cache it
 	db._ans[alias] = identifier
@@ -52,6 +56,8 @@ func (db *Storage) AddRecord(identifier ID, alias string) bool {
 }
 
 func (db *Storage) RemoveRecord(alias string) bool {
+	db._ansLock.Lock()
+	defer db._ansLock.Unlock()
 	if len(alias) == 0 || db._ans[alias] == nil {
 		return false
 	}
@@ -61,6 +67,16 @@ func (db *Storage) RemoveRecord(alias string) bool {
 	return saveANS(db, db._ans)
 }
 
+func (db *Storage) AllRecords() map[string]ID {
+	db._ansLock.RLock()
+	defer db._ansLock.RUnlock()
+	records := make(map[string]ID, len(db._ans))
+	for alias, identifier := range db._ans {
+		records[alias] = identifier
+	}
+	return records
+}
+
 /**
  *  Address Name Service
  *  ~~~~~~~~~~~~~~~~~~~~
@@ -77,29 +93,30 @@ func loadANS(db *Storage) map[string]ID {
 
 	path := ansPath(db)
 	db.log("Loading ANS records: " + path)
-	text := db.readText(path)
-	lines := strings.Split(text, "\n")
-	for _, rec := range lines {
-		if len(rec) == 0 {
-			// skip empty line
-			continue
-		}
-		pair := strings.Split(rec, "\t")
+	db.readLines(path, func(line string) bool {
+		pair := strings.Split(line, "\t")
 		if len(pair) != 2 {
-			db.error("Invalid ANS record: " + rec)
-			continue
+			return false
 		}
-		table[strings.TrimSpace(pair[0])] = IDParse(strings.TrimSpace(pair[1]))
-	}
-	//
-	//  Reserved names
-	//
+		id := IDParse(strings.TrimSpace(pair[1]))
+		if id == nil {
+			return false
+		}
+		table[strings.TrimSpace(pair[0])] = id
+		return true
+	})
+	return reserveANS(table)
+}
+
+//
+//  Reserved names
+//
+func reserveANS(table map[string]ID) map[string]ID {
 	table["all"] = EVERYONE
 	table[EVERYONE.Name()] = EVERYONE
 	table[ANYONE.Name()] = ANYONE
 	table["owner"] = ANYONE
 	table["founder"] = FOUNDER
-
 	return table
 }
 
@@ -28,52 +28,66 @@ package db
 import (
 	. "github.com/dimchat/demo-go/sdk/utils"
 	. "github.com/dimchat/mkm-go/protocol"
-	"strings"
 )
 
 //-------- ContactTable
 
 func (db *Storage) GetContacts(user ID) []ID {
-	arr := db._contacts[user]
-	if arr == nil {
-		arr = loadContacts(db, user)
-		db._contacts[user] = arr
-	}
-	return arr
+	db._locks.Lock(user)
+	defer db._locks.Unlock(user)
+	return copyIDList(getContacts(db, user))
 }
 
 func (db *Storage) AddContact(contact ID, user ID) bool {
-	arr := db.GetContacts(user)
+	db._locks.Lock(user)
+	defer db._locks.Unlock(user)
+	arr := getContacts(db, user)
 	for _, item := range arr {
 		if contact.Equal(item) {
 			// duplicated
 			return false
 		}
 	}
-	arr = append(arr, contact)
-	return db.SaveContacts(arr, user)
+	contacts := make([]ID, 0, len(arr)+1)
+	contacts = append(contacts, arr...)
+	contacts = append(contacts, contact)
+	return setContacts(db, user, contacts)
 }
 
 func (db *Storage) RemoveContact(contact ID, user ID) bool {
-	arr := db.GetContacts(user)
-	var pos = -1
-	for index, id := range arr {
-		if contact.Equal(id) {
-			pos = index
-			break
-		}
-	}
-	if pos == -1 {
+	db._locks.Lock(user)
+	defer db._locks.Unlock(user)
+	contacts, ok := removeID(getContacts(db, user), contact)
+	if !ok {
 		// contact ID not found
 		return false
-	} else {
-		arr = append(arr[:pos], arr[pos+1:]...)
-		return db.SaveContacts(arr, user)
 	}
+	return setContacts(db, user, contacts)
 }
 
 func (db *Storage) SaveContacts(contacts []ID, user ID) bool {
+	db._locks.Lock(user)
+	defer db._locks.Unlock(user)
+	return setContacts(db, user, contacts)
+}
+
+func getContacts(db *Storage, user ID) []ID {
+	db._cacheLock.RLock()
+	arr := db._contacts[user]
+	db._cacheLock.RUnlock()
+	if arr == nil {
+		arr = loadContacts(db, user)
+		db._cacheLock.Lock()
+		db._contacts[user] = arr
+		db._cacheLock.Unlock()
+	}
+	return arr
+}
+
+func setContacts(db *Storage, user ID, contacts []ID) bool {
+	db._cacheLock.Lock()
 	db._contacts[user] = contacts
+	db._cacheLock.Unlock()
 	return saveContacts(db, user, contacts)
 }
 
@@ -91,15 +105,15 @@ func contactsPath(db *Storage, user ID) string {
 func loadContacts(db *Storage, user ID) []ID {
 	path := contactsPath(db, user)
 	db.log("Loading contacts for user: " + user.String())
-	text := db.readText(path)
-	lines := strings.Split(text, "\n")
-	contacts := make([]ID, 0, len(lines))
-	for _, rec := range lines {
-		id := IDParse(rec)
-		if id != nil {
-			contacts = append(contacts, id)
+	contacts := make([]ID, 0)
+	db.readLines(path, func(line string) bool {
+		id := IDParse(line)
+		if id == nil {
+			return false
 		}
-	}
+		contacts = append(contacts, id)
+		return true
+	})
 	return contacts
 }
 
@@ -28,23 +28,67 @@ package db
 import (
 	. "github.com/dimchat/demo-go/sdk/utils"
 	. "github.com/dimchat/mkm-go/protocol"
+	"strings"
 )
 
 //-------- DocumentTable
 
 func (db *Storage) SaveDocument(doc Document) bool {
-	if cacheDocument(db, doc) {
-		return saveDocument(db, doc)
-	} else {
+	identifier := doc.ID()
+	db._locks.Lock(identifier)
+	defer db._locks.Unlock(identifier)
+	// check with current document
+	docType := documentType(doc.Type(), identifier)
+	old := getDocument(db, identifier, docType)
+	if old != nil && documentIsOlder(doc, old) {
+		db.warning("Document expired: " + identifier.String())
+		return false
+	}
+	if cacheDocument(db, doc) == false {
 		return false
 	}
+	// keep the replaced one in history
+	if old != nil && documentIsSame(doc, old) == false {
+		appendDocumentHistory(db, old, docType)
+	}
+	return saveDocument(db, doc)
 }
 
 func (db *Storage) GetDocument(entity ID, docType string) Document {
 	docType = documentType(docType, entity)
+	db._locks.Lock(entity)
+	defer db._locks.Unlock(entity)
 	return getDocument(db, entity, docType)
 }
 
+func (db *Storage) GetDocumentHistory(entity ID, docType string) []Document {
+	docType = documentType(docType, entity)
+	db._locks.Lock(entity)
+	defer db._locks.Unlock(entity)
+	history := loadDocumentHistory(db, entity, docType)
+	// latest first
+	count := len(history)
+	array := make([]Document, count)
+	for index, item := range history {
+		array[count-1-index] = item
+	}
+	return array
+}
+
+// keep only last versions of document
+const MaxDocumentHistory = 16
+
+// check whether the new document is older than the current one
+func documentIsOlder(doc Document, old Document) bool {
+	return doc.Time().Unix() < old.Time().Unix()
+}
+
+// check whether the two documents are the same one
+func documentIsSame(doc Document, old Document) bool {
+	signature := doc.Get("signature")
+	return signature != nil && signature == old.Get("signature")
+}
+
 /**
  *  Document for Entities (User/Group)
  *  ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
@@ -75,6 +119,51 @@ func documentType(docType string, identifier ID) string {
 	}
 }
 
+/**
+ *  Document History
+ *  ~~~~~~~~~~~~~~~~
+ *
+ *  file path: '.dim/mkm/{zzz}/{ADDRESS}/doc_{type}_history.js'
+ *
+ *  format: [{DOCUMENT}, ...]  // oldest first
+ */
+
+func documentHistoryPath(db *Storage, identifier ID, docType string) string {
+	name := strings.TrimSuffix(documentFile(docType), ".js") + "_history.js"
+	return PathJoin(db.mkmDir(identifier), name)
+}
+
+func loadDocumentHistory(db *Storage, identifier ID, docType string) []Document {
+	path := documentHistoryPath(db, identifier, docType)
+	list := db.readList(path)
+	history := make([]Document, 0, len(list))
+	for _, item := range list {
+		doc := DocumentParse(item)
+		if doc == nil {
+			db.error("Invalid document in history: " + path)
+			continue
+		}
+		history = append(history, doc)
+	}
+	return history
+}
+
+func appendDocumentHistory(db *Storage, doc Document, docType string) bool {
+	identifier := doc.ID()
+	history := loadDocumentHistory(db, identifier, docType)
+	history = append(history, doc)
+	if len(history) > MaxDocumentHistory {
+		history = history[len(history)-MaxDocumentHistory:]
+	}
+	list := make([]interface{}, 0, len(history))
+	for _, item := range history {
+		list = append(list, item.Map())
+	}
+	path := documentHistoryPath(db, identifier, docType)
+	db.log("Saving document history: " + path)
+	return db.writeMap(path, list)
+}
+
 func loadDocument(db *Storage, identifier ID, docType string) Document {
 	path := documentPath(db, identifier, docType)
 	db.log("Loading document: " + path)
@@ -93,25 +182,18 @@ var emptyProfile = DocumentCreate(PROFILE, ANYONE, "", "")
 
 func getDocument(db *Storage, identifier ID, docType string) Document {
 	// 1. try from memory cache
-	var doc Document
-	table := db._docs[docType]
-	if table == nil {
-		// FIXME: document type not support?
-		table = make(map[ID]Document)
-		db._docs[docType] = table
-		doc = nil
-	} else {
-		doc = table[identifier]
-	}
+	key := documentKey{identifier.String(), docType}
+	value, _ := db._docs.Get(key)
+	doc, _ := value.(Document)
 	if doc == nil {
 		// 2. try from local storage
 		doc = loadDocument(db, identifier, docType)
 		if doc == nil {
 			// place an empty doc for cache
-			table[identifier] = emptyProfile
+			db.cachePut(db._docs, key, emptyProfile, true)
 		} else {
 			// cache it
-			table[identifier] = doc
+			db.cachePut(db._docs, key, doc, false)
 		}
 	} else if doc == emptyProfile {
 		doc = nil
@@ -124,16 +206,15 @@ func cacheDocument(db *Storage, doc Document) bool {
 	if doc.IsValid() == false {
 		return false
 	}
-	// 2. prepare table with document type
+	// 2. cache it with document type
 	identifier := doc.ID()
 	docType := documentType(doc.Type(), identifier)
-	table := db._docs[docType]
-	if table == nil {
-		// FIXME: document type not support?
-		table = make(map[ID]Document)
-		db._docs[docType] = table
-	}
-	// 3. cache it
-	table[identifier] = doc
+	db.cachePut(db._docs, documentKey{identifier.String(), docType}, doc, false)
 	return true
 }
+
+// cache key for document: (ID, type)
+type documentKey struct {
+	identifier string
+	docType string
+}
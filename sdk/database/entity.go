@@ -0,0 +1,99 @@
+/* license: https://mit-license.org
+ * ==============================================================================
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2021 Albert Moky
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ * ==============================================================================
+ */
+package db
+
+import (
+	. "github.com/dimchat/demo-go/sdk/utils"
+	. "github.com/dimchat/mkm-go/protocol"
+	"sort"
+)
+
+/**
+ *  Get all entities with meta in local storage
+ *
+ * @return entity IDs, sorted
+ */
+func (db *Storage) AllEntities() []ID {
+	array := make([]ID, 0)
+	walkEntities(db, func(address string, dir string) {
+		identifier := entityID(db, address, dir)
+		if identifier != nil {
+			array = append(array, identifier)
+		}
+	})
+	sort.Slice(array, func(i, j int) bool {
+		return array[i].String() < array[j].String()
+	})
+	return array
+}
+
+/**
+ *  Check whether private key files exist for the user,
+ *  the keys are not decrypted here
+ */
+func (db *Storage) HasPrivateKey(user ID) bool {
+	return PathIsExist(identityKeyPath(db, user)) || PathIsExist(communicationKeysPath(db, user))
+}
+
+/**
+ *  Entity Directories
+ *  ~~~~~~~~~~~~~~~~~~
+ *
+ *  file path: '.dim/mkm/{z}/{y}/{x}/{w}/{ADDRESS}/'
+ */
+func walkEntities(db *Storage, handler func(address string, dir string)) {
+	root := PathJoin(db.Root(), "mkm")
+	for _, z := range PathListDir(root) {
+		for _, y := range PathListDir(PathJoin(root, z)) {
+			for _, x := range PathListDir(PathJoin(root, z, y)) {
+				for _, w := range PathListDir(PathJoin(root, z, y, x)) {
+					parent := PathJoin(root, z, y, x, w)
+					for _, address := range PathListDir(parent) {
+						handler(address, PathJoin(parent, address))
+					}
+				}
+			}
+		}
+	}
+}
+
+// build entity ID with meta in the directory
+func entityID(db *Storage, address string, dir string) ID {
+	path := PathJoin(dir, "meta.js")
+	if PathIsExist(path) == false {
+		return nil
+	}
+	meta := MetaParse(db.readMap(path))
+	if meta == nil {
+		return nil
+	}
+	identifier := IDCreate(meta.Seed(), AddressParse(address), "")
+	if identifier == nil || MetaMatchID(meta, identifier) == false {
+		db.error("Meta not match: " + path)
+		return nil
+	}
+	return identifier
+}
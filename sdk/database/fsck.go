@@ -0,0 +1,300 @@
+/* license: https://mit-license.org
+ * ==============================================================================
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2021 Albert Moky
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ * ==============================================================================
+ */
+package db
+
+import (
+	"bytes"
+	. "github.com/dimchat/demo-go/sdk/utils"
+	. "github.com/dimchat/mkm-go/crypto"
+	. "github.com/dimchat/mkm-go/format"
+	. "github.com/dimchat/mkm-go/protocol"
+	"strings"
+)
+
+/**
+ *  Problem found by integrity check
+ */
+type FsckIssue struct {
+	Path     string  // broken file or directory
+	Reason   string
+	Repaired bool    // moved aside or rewritten
+}
+
+/**
+ *  Check integrity of local storage
+ *  ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+ *
+ *  1. meta must match the ID built with its directory address;
+ *  2. documents (and history) must belong to the entity and be signed by meta key;
+ *  3. members must be valid IDs;
+ *  4. identity key must match meta key, communication keys must be valid.
+ *
+ *  In repair mode, broken files are moved aside with suffix '.corrupt-{timestamp}',
+ *  files with some broken records are rewritten with the valid ones;
+ *  private keys will not be checked when keystore is locked.
+ *
+ * @param repair - true to quarantine broken records
+ * @return problems found
+ */
+func (db *Storage) Fsck(repair bool) []*FsckIssue {
+	checker := &fsckChecker{db: db, repair: repair, metas: make(map[string]Meta)}
+	walkEntities(db, checker.checkEntity)
+	checker.checkPrivateKeys()
+	return checker.issues
+}
+
+type fsckChecker struct {
+	db *Storage
+	repair bool
+	metas map[string]Meta  // address -> meta
+	issues []*FsckIssue
+}
+
+// report a problem, quarantine the file in repair mode
+func (checker *fsckChecker) broken(path string, reason string) {
+	issue := &FsckIssue{Path: path, Reason: reason}
+	if checker.repair {
+		issue.Repaired = PathQuarantine(path) != ""
+	}
+	checker.issues = append(checker.issues, issue)
+}
+
+// report a problem which cannot be repaired
+func (checker *fsckChecker) report(path string, reason string) {
+	checker.issues = append(checker.issues, &FsckIssue{Path: path, Reason: reason})
+}
+
+// report some broken records, rewrite the file with valid ones in repair mode
+func (checker *fsckChecker) rewrite(path string, reason string, write func() bool) {
+	issue := &FsckIssue{Path: path, Reason: reason}
+	if checker.repair && PathQuarantine(path) != "" {
+		issue.Repaired = write()
+	}
+	checker.issues = append(checker.issues, issue)
+}
+
+func (checker *fsckChecker) checkEntity(address string, dir string) {
+	db := checker.db
+	path := PathJoin(dir, "meta.js")
+	if PathIsExist(path) == false {
+		checker.report(dir, "meta not found")
+		return
+	}
+	info, _ := ReadJSONFile(path).(map[string]interface{})
+	meta := MetaParse(info)
+	if meta == nil {
+		checker.broken(path, "meta error")
+		return
+	}
+	identifier := IDCreate(meta.Seed(), AddressParse(address), "")
+	if identifier == nil || MetaMatchID(meta, identifier) == false {
+		checker.broken(path, "meta not match address: "+address)
+		return
+	}
+	if db.mkmDir(identifier) != dir {
+		checker.report(dir, "directory misplaced, expected: "+db.mkmDir(identifier))
+		return
+	}
+	checker.metas[address] = meta
+	db._locks.Lock(identifier)
+	defer func() {
+		db._locks.Unlock(identifier)
+		if checker.repair {
+			db.Invalidate(identifier)
+		}
+	}()
+	for _, name := range []string{"visa.js", "doc.js"} {
+		checker.checkDocument(PathJoin(dir, name), identifier, meta)
+	}
+	for _, name := range []string{"visa_history.js", "doc_history.js"} {
+		checker.checkHistory(PathJoin(dir, name), identifier, meta)
+	}
+	checker.checkMembers(PathJoin(dir, "members.txt"))
+}
+
+func checkDocument(info interface{}, identifier ID, meta Meta) string {
+	doc := DocumentParse(info)
+	if doc == nil {
+		return "document error"
+	} else if identifier.Equal(doc.ID()) == false {
+		return "document ID not match: " + doc.ID().String()
+	} else if doc.Verify(meta.Key()) == false {
+		return "document signature not match meta key"
+	}
+	return ""
+}
+
+func (checker *fsckChecker) checkDocument(path string, identifier ID, meta Meta) {
+	if PathIsExist(path) == false {
+		return
+	}
+	reason := checkDocument(ReadJSONFile(path), identifier, meta)
+	if reason != "" {
+		checker.broken(path, reason)
+	}
+}
+
+func (checker *fsckChecker) checkHistory(path string, identifier ID, meta Meta) {
+	if PathIsExist(path) == false {
+		return
+	}
+	list, ok := ReadJSONFile(path).([]interface{})
+	if !ok {
+		checker.broken(path, "document history error")
+		return
+	}
+	valid := make([]interface{}, 0, len(list))
+	for _, item := range list {
+		if checkDocument(item, identifier, meta) == "" {
+			valid = append(valid, item)
+		}
+	}
+	if len(valid) < len(list) {
+		checker.rewrite(path, "invalid documents in history", func() bool {
+			return checker.db.writeMap(path, valid)
+		})
+	}
+}
+
+func (checker *fsckChecker) checkMembers(path string) {
+	if PathIsExist(path) == false {
+		return
+	}
+	lines := strings.Split(ReadTextFile(path), "\n")
+	valid := make([]string, 0, len(lines))
+	invalid := 0
+	for _, rec := range lines {
+		if rec == "" {
+			// skip empty line
+			continue
+		} else if IDParse(rec) == nil {
+			invalid++
+		} else {
+			valid = append(valid, rec)
+		}
+	}
+	if invalid > 0 {
+		checker.rewrite(path, "invalid member IDs", func() bool {
+			text := ""
+			for _, rec := range valid {
+				text = text + rec + "\n"
+			}
+			return checker.db.writeText(path, text)
+		})
+	}
+}
+
+/**
+ *  Private keys: '.dim/private/{ADDRESS}/secret.js', 'secret_keys.js'
+ */
+func (checker *fsckChecker) checkPrivateKeys() {
+	db := checker.db
+	root := PathJoin(db.Root(), "private")
+	if PathIsExist(root) == false {
+		return
+	} else if db.isUnlocked() == false {
+		checker.report(root, "keystore locked, private keys not checked")
+		return
+	}
+	for _, address := range PathListDir(root) {
+		dir := PathJoin(root, address)
+		if PathListDir(dir) == nil {
+			// not a directory, e.g. 'keystore.js'
+			continue
+		}
+		meta := checker.metas[address]
+		if meta == nil {
+			checker.report(dir, "meta not found for private keys")
+			continue
+		}
+		identifier := IDCreate(meta.Seed(), AddressParse(address), "")
+		db._locks.Lock(identifier)
+		checker.checkIdentityKey(PathJoin(dir, "secret.js"), meta)
+		checker.checkCommunicationKeys(PathJoin(dir, "secret_keys.js"), identifier)
+		db._locks.Unlock(identifier)
+		if checker.repair {
+			db.Invalidate(identifier)
+		}
+	}
+}
+
+var fsckCheckData = []byte("DIM fsck")
+
+func (checker *fsckChecker) checkIdentityKey(path string, meta Meta) {
+	if PathIsExist(path) == false {
+		return
+	}
+	data := checker.db.readSecret(path)
+	if data == nil {
+		checker.report(path, "failed to decrypt identity key")
+		return
+	}
+	key := PrivateKeyParse(decodeJSONMap(UTF8Decode(data)))
+	if key == nil {
+		checker.broken(path, "identity key error")
+	} else if meta.Key().Verify(fsckCheckData, key.Sign(fsckCheckData)) == false {
+		checker.broken(path, "identity key not match meta key")
+	}
+}
+
+func (checker *fsckChecker) checkCommunicationKeys(path string, identifier ID) {
+	if PathIsExist(path) == false {
+		return
+	}
+	data := checker.db.readSecret(path)
+	if data == nil {
+		checker.report(path, "failed to decrypt communication keys")
+		return
+	}
+	list := decodeJSONList(UTF8Decode(data))
+	if list == nil {
+		checker.broken(path, "communication keys error")
+		return
+	}
+	keys := make([]PrivateKey, 0, len(list))
+	for _, item := range list {
+		key := PrivateKeyParse(item)
+		if key == nil {
+			checker.broken(path, "communication key error")
+			return
+		}
+		keys = append(keys, key)
+	}
+	// the visa key should be paired with one of them
+	info, _ := ReadJSONFile(documentPath(checker.db, identifier, VISA)).(map[string]interface{})
+	visa, ok := DocumentParse(info).(Visa)
+	if !ok || visa == nil || visa.Key() == nil {
+		return
+	}
+	encrypted := visa.Key().Encrypt(fsckCheckData)
+	for _, key := range keys {
+		decKey, ok := key.(DecryptKey)
+		if ok && bytes.Equal(decKey.Decrypt(encrypted), fsckCheckData) {
+			return
+		}
+	}
+	checker.report(path, "no communication key for visa key")
+}
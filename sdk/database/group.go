@@ -28,72 +28,201 @@ package db
 import (
 	. "github.com/dimchat/demo-go/sdk/utils"
 	. "github.com/dimchat/mkm-go/protocol"
-	"strings"
+	. "github.com/dimchat/mkm-go/types"
 )
 
+/**
+ *  Membership history of groups
+ *
+ *  NOTICE: only recorded by local storage (Storage),
+ *          MemoryStorage & SQLStorage don't implement it
+ */
+type MembershipHistoryTable interface {
+
+	/**
+	 *  Get membership history of the group
+	 *
+	 * @param group - group ID
+	 * @return records with action ("add" or "remove"), member ID and time
+	 */
+	GetMembershipHistory(group ID) []map[string]interface{}
+}
+
 //-------- GroupTable
 
 func (db *Storage) GetFounder(group ID) ID {
-	// TODO: get group founder
-	return nil
+	db._locks.Lock(group)
+	defer db._locks.Unlock(group)
+	return getGroupRecord(db, group).founder
 }
 
 func (db *Storage) GetOwner(group ID) ID {
-	// TODO: get group owner
-	return nil
+	db._locks.Lock(group)
+	defer db._locks.Unlock(group)
+	return getGroupRecord(db, group).owner
 }
 
 func (db *Storage) GetMembers(group ID) []ID {
-	arr := db._members[group]
-	if arr == nil {
-		arr = loadMembers(db, group)
-		db._members[group] = arr
-	}
-	return arr
+	db._locks.Lock(group)
+	defer db._locks.Unlock(group)
+	// return a copy, the cached one will be updated with lock
+	return copyIDList(getMembers(db, group))
 }
 
 func (db *Storage) GetAssistants(group ID) []ID {
-	// TODO: get group assistants
-	return nil
+	db._locks.Lock(group)
+	defer db._locks.Unlock(group)
+	return copyIDList(getGroupRecord(db, group).assistants)
 }
 
 func (db *Storage) AddMember(member ID, group ID) bool {
-	arr := db.GetMembers(group)
+	db._locks.Lock(group)
+	defer db._locks.Unlock(group)
+	arr := getMembers(db, group)
 	for _, item := range arr {
 		if member.Equal(item) {
 			// duplicated
 			return false
 		}
 	}
-	arr = append(arr, member)
-	return db.SaveMembers(arr, group)
+	members := make([]ID, 0, len(arr)+1)
+	members = append(members, arr...)
+	members = append(members, member)
+	return setMembers(db, group, members)
 }
 
 func (db *Storage) RemoveMember(member ID, group ID) bool {
-	arr := db.GetMembers(group)
-	var pos = -1
-	for index, id := range arr {
-		if member.Equal(id) {
-			pos = index
-			break
-		}
-	}
-	if pos == -1 {
-		// contact ID not found
+	db._locks.Lock(group)
+	defer db._locks.Unlock(group)
+	members, ok := removeID(getMembers(db, group), member)
+	if !ok {
+		// member ID not found
 		return false
-	} else {
-		arr = append(arr[:pos], arr[pos+1:]...)
-		return db.SaveMembers(arr, group)
 	}
+	return setMembers(db, group, members)
 }
 
 func (db *Storage) SaveMembers(members []ID, group ID) bool {
-	db._members[group] = members
-	return saveMembers(db, group, members)
+	db._locks.Lock(group)
+	defer db._locks.Unlock(group)
+	return setMembers(db, group, members)
+}
+
+func (db *Storage) SaveFounder(founder ID, group ID) bool {
+	db._locks.Lock(group)
+	defer db._locks.Unlock(group)
+	record := getGroupRecord(db, group)
+	record.founder = founder
+	return saveGroupRecord(db, group, record)
+}
+
+func (db *Storage) SaveOwner(owner ID, group ID) bool {
+	db._locks.Lock(group)
+	defer db._locks.Unlock(group)
+	record := getGroupRecord(db, group)
+	record.owner = owner
+	return saveGroupRecord(db, group, record)
+}
+
+func (db *Storage) SaveAssistants(bots []ID, group ID) bool {
+	db._locks.Lock(group)
+	defer db._locks.Unlock(group)
+	record := getGroupRecord(db, group)
+	record.assistants = bots
+	return saveGroupRecord(db, group, record)
 }
 
+//-------- MembershipHistoryTable
+
+func (db *Storage) GetMembershipHistory(group ID) []map[string]interface{} {
+	db._locks.Lock(group)
+	defer db._locks.Unlock(group)
+	history := getGroupRecord(db, group).history
+	records := make([]map[string]interface{}, 0, len(history))
+	for _, item := range history {
+		record := make(map[string]interface{}, len(item))
+		for key, value := range item {
+			record[key] = value
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+/**
+ *  Remove members & group record of the group,
+ *  meta and bulletin will be kept for verifying history messages
+ */
 func (db *Storage) RemoveGroup(group ID) bool {
-	// TODO: remove group info
+	db._locks.Lock(group)
+	defer db._locks.Unlock(group)
+	db._cacheLock.Lock()
+	delete(db._members, group)
+	delete(db._groups, group)
+	db._cacheLock.Unlock()
+	path := membersPath(db, group)
+	ok1 := !PathIsExist(path) || PathRemove(path)
+	path = groupRecordPath(db, group)
+	ok2 := !PathIsExist(path) || PathRemove(path)
+	db.log("Removed group info: " + group.String())
+	return ok1 && ok2
+}
+
+func getMembers(db *Storage, group ID) []ID {
+	db._cacheLock.RLock()
+	arr := db._members[group]
+	db._cacheLock.RUnlock()
+	if arr == nil {
+		arr = loadMembers(db, group)
+		db._cacheLock.Lock()
+		db._members[group] = arr
+		db._cacheLock.Unlock()
+	}
+	return arr
+}
+
+func setMembers(db *Storage, group ID, members []ID) bool {
+	old := getMembers(db, group)
+	db._cacheLock.Lock()
+	db._members[group] = members
+	db._cacheLock.Unlock()
+	if saveMembers(db, group, members) == false {
+		return false
+	}
+	// record membership changes
+	record := getGroupRecord(db, group)
+	changed := false
+	for _, item := range members {
+		if containsID(old, item) == false {
+			record.addHistory("add", item)
+			changed = true
+		}
+	}
+	for _, item := range old {
+		if containsID(members, item) == false {
+			record.addHistory("remove", item)
+			changed = true
+		}
+	}
+	if changed {
+		return saveGroupRecord(db, group, record)
+	}
+	return true
+}
+
+func copyIDList(array []ID) []ID {
+	if array == nil {
+		return nil
+	}
+	return append(make([]ID, 0, len(array)), array...)
+}
+
+func containsID(array []ID, identifier ID) bool {
+	for _, item := range array {
+		if identifier.Equal(item) {
+			return true
+		}
+	}
 	return false
 }
 
@@ -111,15 +240,15 @@ func membersPath(db *Storage, group ID) string {
 func loadMembers(db *Storage, group ID) []ID {
 	path := membersPath(db, group)
 	db.log("Loading members for group: " + group.String())
-	text := db.readText(path)
-	lines := strings.Split(text, "\n")
-	members := make([]ID, 0, len(lines))
-	for _, rec := range lines {
-		id := IDParse(rec)
-		if id != nil {
-			members = append(members, id)
+	members := make([]ID, 0)
+	db.readLines(path, func(line string) bool {
+		id := IDParse(line)
+		if id == nil {
+			return false
 		}
-	}
+		members = append(members, id)
+		return true
+	})
 	return members
 }
 
@@ -133,3 +262,102 @@ func saveMembers(db *Storage, group ID, members []ID) bool {
 	db.log("Saving members for group: " + group.String())
 	return db.writeText(path, text)
 }
+
+/**
+ *  Group Record
+ *  ~~~~~~~~~~~~
+ *
+ *  file path: '.dim/mkm/{zzz}/{ADDRESS}/group.js'
+ *
+ *  format: {
+ *      founder    : "{FOUNDER_ID}",
+ *      owner      : "{OWNER_ID}",
+ *      assistants : ["{BOT_ID}", ...],
+ *      history    : [{action: "add", member: "{ID}", time: 123}, ...]
+ *  }
+ */
+
+// keep only last records of membership history
+const MaxMembershipHistory = 1024
+
+type groupRecord struct {
+	founder ID
+	owner ID
+	assistants []ID
+	history []map[string]interface{}
+}
+
+func (record *groupRecord) addHistory(action string, member ID) {
+	item := make(map[string]interface{})
+	item["action"] = action
+	item["member"] = member.String()
+	item["time"] = Timestamp(TimeNow())
+	history := append(record.history, item)
+	if len(history) > MaxMembershipHistory {
+		history = history[len(history)-MaxMembershipHistory:]
+	}
+	record.history = history
+}
+
+func groupRecordPath(db *Storage, group ID) string {
+	return PathJoin(db.mkmDir(group), "group.js")
+}
+
+func loadGroupRecord(db *Storage, group ID) *groupRecord {
+	path := groupRecordPath(db, group)
+	db.log("Loading group record: " + path)
+	record := new(groupRecord)
+	record.assistants = make([]ID, 0)
+	record.history = make([]map[string]interface{}, 0)
+	info := db.readMap(path)
+	if info == nil {
+		return record
+	}
+	record.founder = IDParse(info["founder"])
+	record.owner = IDParse(info["owner"])
+	if bots, ok := info["assistants"].([]interface{}); ok {
+		for _, item := range bots {
+			id := IDParse(item)
+			if id != nil {
+				record.assistants = append(record.assistants, id)
+			}
+		}
+	}
+	if history, ok := info["history"].([]interface{}); ok {
+		for _, item := range history {
+			if dict, ok := item.(map[string]interface{}); ok {
+				record.history = append(record.history, dict)
+			}
+		}
+	}
+	return record
+}
+
+func saveGroupRecord(db *Storage, group ID, record *groupRecord) bool {
+	info := make(map[string]interface{})
+	if record.founder != nil {
+		info["founder"] = record.founder.String()
+	}
+	if record.owner != nil {
+		info["owner"] = record.owner.String()
+	}
+	info["assistants"] = IDRevert(record.assistants)
+	info["history"] = record.history
+	path := groupRecordPath(db, group)
+	db.log("Saving group record: " + path)
+	return db.writeMap(path, info)
+}
+
+// NOTICE: fields of the record are only touched with the group locked
+func getGroupRecord(db *Storage, group ID) *groupRecord {
+	db._cacheLock.RLock()
+	record := db._groups[group]
+	db._cacheLock.RUnlock()
+	if record == nil {
+		record = loadGroupRecord(db, group)
+		db._cacheLock.Lock()
+		db._groups[group] = record
+		db._cacheLock.Unlock()
+	}
+	return record
+}
@@ -0,0 +1,328 @@
+/* license: https://mit-license.org
+ * ==============================================================================
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2021 Albert Moky
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ * ==============================================================================
+ */
+package db
+
+import (
+	. "github.com/dimchat/demo-go/sdk/extensions"
+	. "github.com/dimchat/demo-go/sdk/utils"
+	. "github.com/dimchat/mkm-go/crypto"
+	. "github.com/dimchat/mkm-go/format"
+	. "github.com/dimchat/sdk-go/plugins/crypto"
+	. "github.com/dimchat/sdk-go/plugins/types"
+)
+
+/**
+ *  Keystore for private key encryption
+ */
+type Keystore interface {
+
+	/**
+	 *  Check whether secrets are encrypted with passphrase
+	 */
+	IsEncrypted() bool
+
+	/**
+	 *  Unlock secrets with passphrase
+	 *
+	 * @param password - passphrase
+	 * @return false on wrong passphrase
+	 */
+	SetPassword(password string) bool
+
+	/**
+	 *  Re-encrypt all secrets with new passphrase,
+	 *  empty string means no encryption
+	 *
+	 * @param password - new passphrase
+	 * @return false on failed
+	 */
+	ResetPassword(password string) bool
+}
+
+/**
+ *  Password for private key encryption
+ *  ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+ */
+func (db *Storage) Password() SymmetricKey {
+	db._cacheLock.RLock()
+	defer db._cacheLock.RUnlock()
+	return db._password
+}
+func (db *Storage) setPassword(key SymmetricKey) {
+	db._cacheLock.Lock()
+	db._password = key
+	db._cacheLock.Unlock()
+}
+
+func (db *Storage) IsEncrypted() bool {
+	info := db.readMap(keystorePath(db))
+	return info != nil && info["kdf"] != keystoreNone
+}
+
+// check whether the current password can decrypt secrets
+func (db *Storage) isUnlocked() bool {
+	info := db.readMap(keystorePath(db))
+	if info == nil || info["kdf"] == keystoreNone {
+		return true
+	}
+	check, _ := info["check"].(string)
+	plaintext := db.Password().Decrypt(Base64Decode(check))
+	return plaintext != nil && UTF8Decode(plaintext) == keystoreCheckText
+}
+
+func (db *Storage) SetPassword(password string) bool {
+	info := db.readMap(keystorePath(db))
+	if info == nil || info["kdf"] == keystoreNone {
+		if password == "" {
+			db.setPassword(GetPlainKey())
+			return true
+		} else if len(secretFiles(db, "")) > 0 {
+			db.error("Plain secrets exist, reset password to encrypt them")
+			return false
+		}
+		info = createKeystore(password)
+		if saveKeystore(db, info) == false {
+			return false
+		}
+	}
+	key := keystoreKey(info, password)
+	if key == nil {
+		db.error("Wrong password for keystore")
+		return false
+	}
+	db.setPassword(key)
+	if keystoreOutdated(info) {
+		db.warning("Upgrading keystore: " + keystorePath(db))
+		db.ResetPassword(password)
+	}
+	return true
+}
+
+/**
+ *  Re-encrypt secrets in 3 steps:
+ *
+ *      1. stage: write secrets encrypted with new password to '*.new',
+ *                and the new keystore to 'keystore.js.new';
+ *      2. commit: rename 'keystore.js.new' to 'keystore.js';
+ *      3. apply: rename all '*.new' to the secret files.
+ *
+ *  so an interrupted process can be recovered by 'recoverKeystore()'
+ */
+func (db *Storage) ResetPassword(password string) bool {
+	recoverKeystore(db)
+	// 1. decrypt all secrets with current password
+	files := secretFiles(db, "")
+	secrets := make(map[string][]byte, len(files))
+	for _, path := range files {
+		data := db.readSecret(path)
+		if data == nil {
+			db.error("Failed to decrypt secret: " + path)
+			return false
+		}
+		secrets[path] = data
+	}
+	// 2. prepare new password
+	var info map[string]interface{}
+	var key SymmetricKey
+	if password == "" {
+		info = map[string]interface{}{"kdf": keystoreNone}
+		key = GetPlainKey()
+	} else {
+		info = createKeystore(password)
+		key = keystoreKey(info, password)
+	}
+	// 3. stage secrets encrypted with new password & the new keystore
+	for path, data := range secrets {
+		staged := path + keystoreStaged
+		if db.prepareDir(staged) == false || WriteBinaryFile(staged, key.Encrypt(data)) == false {
+			db.error("Failed to stage secret: " + staged)
+			discardStagedSecrets(db)
+			return false
+		}
+	}
+	path := keystorePath(db)
+	db.log("Staging keystore: " + path + keystoreStaged)
+	if db.writeMap(path + keystoreStaged, info) == false {
+		discardStagedSecrets(db)
+		return false
+	}
+	// 4. commit
+	if PathRename(path + keystoreStaged, path) == false {
+		db.error("Failed to commit keystore: " + path)
+		discardStagedSecrets(db)
+		_ = PathRemove(path + keystoreStaged)
+		return false
+	}
+	db.setPassword(key)
+	// 5. apply
+	return applyStagedSecrets(db)
+}
+
+/**
+ *  Finish the interrupted 'ResetPassword()':
+ *      if the new keystore is not committed, discard staged secrets;
+ *      else, apply staged secrets.
+ */
+func recoverKeystore(db *Storage) {
+	path := keystorePath(db)
+	if PathIsExist(path + keystoreStaged) {
+		db.warning("Rolling back uncommitted keystore: " + path)
+		// NOTICE: remove the staged keystore at last
+		discardStagedSecrets(db)
+		_ = PathRemove(path + keystoreStaged)
+	} else if len(secretFiles(db, keystoreStaged)) > 0 {
+		db.warning("Applying committed keystore: " + path)
+		applyStagedSecrets(db)
+	} else if info := db.readMap(path); info != nil && info["kdf"] == keystoreNone {
+		_ = PathRemove(path)
+	}
+}
+
+func discardStagedSecrets(db *Storage) {
+	for _, staged := range secretFiles(db, keystoreStaged) {
+		if PathRemove(staged) == false {
+			db.error("Failed to remove staged secret: " + staged)
+		}
+	}
+}
+
+func applyStagedSecrets(db *Storage) bool {
+	for _, staged := range secretFiles(db, keystoreStaged) {
+		target := staged[:len(staged) - len(keystoreStaged)]
+		if PathRename(staged, target) == false {
+			db.error("Failed to apply staged secret: " + staged)
+			return false
+		}
+	}
+	// no encryption now
+	path := keystorePath(db)
+	if info := db.readMap(path); info != nil && info["kdf"] == keystoreNone {
+		return PathRemove(path)
+	}
+	return true
+}
+
+/**
+ *  Keystore
+ *  ~~~~~~~~
+ *
+ *  file path: '.dim/private/keystore.js'
+ *
+ *  format: {
+ *      version : 2,           // 2: random IV before each encrypted secret
+ *      kdf     : "scrypt",
+ *      salt    : "{BASE64}",
+ *      N       : 32768,
+ *      r       : 8,
+ *      p       : 1,
+ *      check   : "{BASE64}"   // encrypted check text for verifying passphrase
+ *  }
+ */
+
+const keystoreCheckText = "DIM Keystore"
+
+const keystoreVersion = 2
+
+const (
+	keystoreNone = "none"    // kdf for committing plain secrets
+	keystoreStaged = ".new"  // suffix for staged files
+)
+
+func keystorePath(db *Storage) string {
+	return PathJoin(db.Root(), "private", "keystore.js")
+}
+
+func createKeystore(password string) map[string]interface{} {
+	salt := RandomBytes(16)
+	info := make(map[string]interface{})
+	info["version"] = keystoreVersion
+	info["kdf"] = "scrypt"
+	info["salt"] = Base64Encode(salt)
+	info["N"] = ScryptN
+	info["r"] = ScryptR
+	info["p"] = ScryptP
+	key := DerivePassword(password, salt, ScryptN, ScryptR, ScryptP)
+	info["check"] = Base64Encode(key.Encrypt(UTF8Encode(keystoreCheckText)))
+	return info
+}
+
+func saveKeystore(db *Storage, info map[string]interface{}) bool {
+	path := keystorePath(db)
+	db.log("Saving keystore: " + path)
+	return db.writeMap(path, info)
+}
+
+// derive password and verify with check text
+func keystoreKey(info map[string]interface{}, password string) SymmetricKey {
+	if info["kdf"] != "scrypt" {
+		return nil
+	}
+	b64, _ := info["salt"].(string)
+	N, ok1 := ToInt64(info["N"])
+	r, ok2 := ToInt64(info["r"])
+	p, ok3 := ToInt64(info["p"])
+	if !ok1 || !ok2 || !ok3 || !ScryptParamsValid(int(N), int(r), int(p)) {
+		return nil
+	}
+	var key SymmetricKey
+	if keystoreOutdated(info) {
+		// secrets encrypted by old version, without IV
+		key = DeriveLegacyPassword(password, Base64Decode(b64), int(N), int(r), int(p))
+	} else {
+		key = DerivePassword(password, Base64Decode(b64), int(N), int(r), int(p))
+	}
+	if key == nil {
+		return nil
+	}
+	check, _ := info["check"].(string)
+	plaintext := key.Decrypt(Base64Decode(check))
+	if plaintext == nil || UTF8Decode(plaintext) != keystoreCheckText {
+		return nil
+	}
+	return key
+}
+
+// secrets encrypted with the same IV, should be re-encrypted by 'ResetPassword()'
+func keystoreOutdated(info map[string]interface{}) bool {
+	version, _ := ToInt64(info["version"])
+	return info["kdf"] == "scrypt" && version < keystoreVersion
+}
+
+// all encrypted files: '.dim/private/{ADDRESS}/*', with suffix for staged files
+func secretFiles(db *Storage, suffix string) []string {
+	files := make([]string, 0)
+	root := PathJoin(db.Root(), "private")
+	for _, address := range PathListDir(root) {
+		dir := PathJoin(root, address)
+		for _, name := range []string{"secret.js", "secret_keys.js", "msg_keys.js"} {
+			path := PathJoin(dir, name + suffix)
+			if PathIsExist(path) {
+				files = append(files, path)
+			}
+		}
+	}
+	return files
+}
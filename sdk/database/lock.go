@@ -0,0 +1,63 @@
+/* license: https://mit-license.org
+ * ==============================================================================
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2021 Albert Moky
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ * ==============================================================================
+ */
+package db
+
+import (
+	. "github.com/dimchat/mkm-go/protocol"
+	"hash/fnv"
+	"sync"
+)
+
+const lockStripes = 64
+
+/**
+ *  Entity Locks
+ *  ~~~~~~~~~~~~
+ *
+ *  Striped locks for serializing load/modify/save on the same ID,
+ *  while operations on different IDs won't wait for each other
+ *  (unless they fall into the same stripe).
+ *
+ *  NOTICE: lock only one ID at a time, and always lock the ID
+ *          before any table lock, to avoid dead lock.
+ */
+type lockSet struct {
+	_stripes [lockStripes]sync.Mutex
+}
+
+func (locks *lockSet) stripe(identifier ID) *sync.Mutex {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(identifier.String()))
+	return &locks._stripes[hash.Sum32() % lockStripes]
+}
+
+func (locks *lockSet) Lock(identifier ID) {
+	locks.stripe(identifier).Lock()
+}
+
+func (locks *lockSet) Unlock(identifier ID) {
+	locks.stripe(identifier).Unlock()
+}
@@ -36,16 +36,23 @@ import (
 //-------- LoginTable
 
 func (db *Storage) GetLoginCommand(user ID) LoginCommand {
+	db._locks.Lock(user)
+	defer db._locks.Unlock(user)
 	cmd, _ := getLoginInfo(db, user)
 	return cmd
 }
 
 func (db *Storage) GetLoginMessage(user ID) ReliableMessage {
+	db._locks.Lock(user)
+	defer db._locks.Unlock(user)
 	_, msg := getLoginInfo(db, user)
 	return msg
 }
 
 func (db *Storage) SaveLoginCommandMessage(cmd LoginCommand, msg ReliableMessage) bool {
+	user := cmd.ID()
+	db._locks.Lock(user)
+	defer db._locks.Unlock(user)
 	if cacheLoginInfo(db, cmd, msg) {
 		return saveLoginInfo(db, cmd, msg)
 	} else {
@@ -91,23 +98,24 @@ var emptyMessage = NewReliableMessage(nil)
 
 func getLoginInfo(db *Storage, identifier ID) (cmd LoginCommand, msg ReliableMessage) {
 	// 1. try from memory cache
-	msg = db._loginMessages[identifier]
-	if msg == nil {
+	value, _ := db._logins.Get(identifier)
+	info, _ := value.(*loginInfo)
+	if info == nil {
 		// 2. try from local storage
 		cmd, msg = loadLoginInfo(db, identifier)
 		if msg == nil {
 			// place an empty message for cache
-			db._loginMessages[identifier] = emptyMessage
+			db.cachePut(db._logins, identifier, &loginInfo{nil, emptyMessage}, true)
 		} else {
 			// cache them
-			db._loginCommands[identifier] = cmd
-			db._loginMessages[identifier] = msg
+			db.cachePut(db._logins, identifier, &loginInfo{cmd, msg}, false)
 		}
-	} else if msg == emptyMessage {
+	} else if info.msg == emptyMessage {
 		cmd = nil
 		msg = nil
 	} else {
-		cmd = db._loginCommands[identifier]
+		cmd = info.cmd
+		msg = info.msg
 	}
 	return cmd, msg
 }
@@ -129,7 +137,12 @@ func cacheLoginInfo(db *Storage, cmd LoginCommand, msg ReliableMessage) bool {
 		}
 	}
 	// 3. cache them
-	db._loginCommands[identifier] = cmd
-	db._loginMessages[identifier] = msg
+	db.cachePut(db._logins, identifier, &loginInfo{cmd, msg}, false)
 	return true
 }
+
+// cache value for login info
+type loginInfo struct {
+	cmd LoginCommand
+	msg ReliableMessage
+}
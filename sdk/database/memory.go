@@ -0,0 +1,517 @@
+/* license: https://mit-license.org
+ * ==============================================================================
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2021 Albert Moky
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ * ==============================================================================
+ */
+package db
+
+import (
+	. "github.com/dimchat/demo-go/sdk/common/db"
+	. "github.com/dimchat/dkd-go/protocol"
+	. "github.com/dimchat/mkm-go/crypto"
+	. "github.com/dimchat/mkm-go/protocol"
+	. "github.com/dimchat/mkm-go/types"
+	. "github.com/dimchat/sdk-go/dimp/protocol"
+	"sync"
+)
+
+/**
+ *  Memory Storage
+ *  ~~~~~~~~~~~~~~
+ *
+ *  Database implementation without persistence,
+ *  for unit tests and ephemeral nodes
+ */
+type MemoryStorage struct {
+	Database
+
+	// all operations are in memory, one lock is enough
+	_lock sync.RWMutex
+
+	_identityKeys map[ID]PrivateKey         // meta keys: ID -> SK
+	_communicationKeys map[ID][]PrivateKey  // visa keys: ID -> []SK
+
+	_metas map[ID]Meta                // meta: ID -> meta
+	_docs map[string]map[ID]Document  // document: type -> ID -> doc
+	_docHistory map[documentKey][]Document  // document history: (ID, type) -> []doc
+
+	_msgKeys map[ID]map[ID]SymmetricKey  // msg keys: sender -> receiver -> PW
+
+	_ans map[string]ID                // ANS: string -> ID
+
+	_loginCommands map[ID]LoginCommand     // ID -> Login Command
+	_loginMessages map[ID]ReliableMessage  // ID -> Login Message
+
+	_users []ID
+	_contacts map[ID][]ID             // user contacts: ID -> []ID
+
+	_members map[ID][]ID              // group members: ID -> []ID
+	_founders map[ID]ID               // group founder: ID -> ID
+	_owners map[ID]ID                 // group owner: ID -> ID
+	_assistants map[ID][]ID           // group assistants: ID -> []ID
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	db := new(MemoryStorage)
+	db.Init()
+	return db
+}
+
+func (db *MemoryStorage) Init() *MemoryStorage {
+	db._identityKeys = make(map[ID]PrivateKey)
+	db._communicationKeys = make(map[ID][]PrivateKey)
+	db._metas = make(map[ID]Meta)
+	db._docs = make(map[string]map[ID]Document)
+	db._docHistory = make(map[documentKey][]Document)
+	db._msgKeys = make(map[ID]map[ID]SymmetricKey)
+	db._ans = reserveANS(make(map[string]ID))
+	db._loginCommands = make(map[ID]LoginCommand)
+	db._loginMessages = make(map[ID]ReliableMessage)
+	db._users = make([]ID, 0, 1)
+	db._contacts = make(map[ID][]ID)
+	db._members = make(map[ID][]ID)
+	db._founders = make(map[ID]ID)
+	db._owners = make(map[ID]ID)
+	db._assistants = make(map[ID][]ID)
+	return db
+}
+
+// no root directory for memory storage
+func (db *MemoryStorage) SetRoot(_ string) {
+}
+
+//-------- PrivateKeyTable
+
+func (db *MemoryStorage) SavePrivateKey(user ID, key PrivateKey, keyType string, sign bool, decrypt bool) bool {
+	db._lock.Lock()
+	defer db._lock.Unlock()
+	if keyType == META_KEY {
+		if db._identityKeys[user] != nil {
+			// identity key won't change
+			return false
+		}
+		db._identityKeys[user] = key
+		return true
+	}
+	keys := updateKeys(db._communicationKeys[user], key, sign)
+	if keys == nil {
+		return false
+	}
+	db._communicationKeys[user] = keys
+	return true
+}
+
+func (db *MemoryStorage) RemovePrivateKey(user ID, key PrivateKey, keyType string) bool {
+	if keyType == META_KEY {
+		// identity key won't change
+		return false
+	}
+	db._lock.Lock()
+	defer db._lock.Unlock()
+	keys := db._communicationKeys[user]
+	index := findKey(keys, key)
+	if index < 0 {
+		return false
+	}
+	db._communicationKeys[user] = removeKey(keys, index)
+	return true
+}
+
+func (db *MemoryStorage) GetPrivateKeysForDecryption(user ID) []DecryptKey {
+	db._lock.RLock()
+	defer db._lock.RUnlock()
+	msgKeys := db._communicationKeys[user]
+	keys := make([]DecryptKey, 0, len(msgKeys) + 1)
+	for _, item := range msgKeys {
+		decKey, ok := item.(DecryptKey)
+		if ok && decKey != nil {
+			keys = append(keys, decKey)
+		}
+	}
+	idKey := db._identityKeys[user]
+	decKey, ok := idKey.(DecryptKey)
+	if ok && decKey != nil && findKey(msgKeys, idKey) < 0 {
+		keys = append(keys, decKey)
+	}
+	return keys
+}
+
+func (db *MemoryStorage) GetPrivateKeyForSignature(user ID) PrivateKey {
+	db._lock.RLock()
+	defer db._lock.RUnlock()
+	keys := db._communicationKeys[user]
+	if len(keys) > 0 {
+		// sign message with communication key
+		return keys[0]
+	} else {
+		// if communication keys not exists, use identity key to sign message
+		return db._identityKeys[user]
+	}
+}
+
+func (db *MemoryStorage) GetPrivateKeyForVisaSignature(user ID) PrivateKey {
+	db._lock.RLock()
+	defer db._lock.RUnlock()
+	return db._identityKeys[user]
+}
+
+//-------- MetaTable
+
+func (db *MemoryStorage) SaveMeta(meta Meta, entity ID) bool {
+	db._lock.Lock()
+	defer db._lock.Unlock()
+	if MetaMatchID(meta, entity) {
+		db._metas[entity] = meta
+		return true
+	} else {
+		return false
+	}
+}
+
+func (db *MemoryStorage) GetMeta(entity ID) Meta {
+	db._lock.RLock()
+	defer db._lock.RUnlock()
+	return db._metas[entity]
+}
+
+//-------- DocumentTable
+
+func (db *MemoryStorage) SaveDocument(doc Document) bool {
+	db._lock.Lock()
+	defer db._lock.Unlock()
+	if doc.IsValid() == false {
+		return false
+	}
+	identifier := doc.ID()
+	docType := documentType(doc.Type(), identifier)
+	table := db._docs[docType]
+	if table == nil {
+		table = make(map[ID]Document)
+		db._docs[docType] = table
+	}
+	old := table[identifier]
+	if old != nil {
+		if documentIsOlder(doc, old) {
+			return false
+		}
+		if documentIsSame(doc, old) == false {
+			// keep the replaced one in history
+			key := documentKey{identifier.String(), docType}
+			history := append(db._docHistory[key], old)
+			if len(history) > MaxDocumentHistory {
+				history = history[len(history)-MaxDocumentHistory:]
+			}
+			db._docHistory[key] = history
+		}
+	}
+	table[identifier] = doc
+	return true
+}
+
+func (db *MemoryStorage) GetDocument(entity ID, docType string) Document {
+	db._lock.RLock()
+	defer db._lock.RUnlock()
+	docType = documentType(docType, entity)
+	return db._docs[docType][entity]
+}
+
+func (db *MemoryStorage) GetDocumentHistory(entity ID, docType string) []Document {
+	db._lock.RLock()
+	defer db._lock.RUnlock()
+	docType = documentType(docType, entity)
+	history := db._docHistory[documentKey{entity.String(), docType}]
+	// latest first
+	count := len(history)
+	array := make([]Document, count)
+	for index, item := range history {
+		array[count-1-index] = item
+	}
+	return array
+}
+
+//-------- MsgKeyTable
+
+func (db *MemoryStorage) GetKey(from ID, to ID) SymmetricKey {
+	db._lock.RLock()
+	defer db._lock.RUnlock()
+	return db._msgKeys[from][to]
+}
+
+func (db *MemoryStorage) SaveKey(from ID, to ID, key SymmetricKey) bool {
+	db._lock.Lock()
+	defer db._lock.Unlock()
+	table := db._msgKeys[from]
+	if table == nil {
+		table = make(map[ID]SymmetricKey)
+		db._msgKeys[from] = table
+	}
+	table[to] = key
+	return true
+}
+
+//-------- AddressNameTable
+
+func (db *MemoryStorage) GetIdentifier(alias string) ID {
+	db._lock.RLock()
+	defer db._lock.RUnlock()
+	return db._ans[alias]
+}
+
+func (db *MemoryStorage) AddRecord(identifier ID, alias string) bool {
+	db._lock.Lock()
+	defer db._lock.Unlock()
+	if len(alias) == 0 || ValueIsNil(identifier) {
+		return false
+	}
+	db._ans[alias] = identifier
+	return true
+}
+
+func (db *MemoryStorage) RemoveRecord(alias string) bool {
+	db._lock.Lock()
+	defer db._lock.Unlock()
+	if len(alias) == 0 || db._ans[alias] == nil {
+		return false
+	}
+	delete(db._ans, alias)
+	return true
+}
+
+func (db *MemoryStorage) AllRecords() map[string]ID {
+	db._lock.RLock()
+	defer db._lock.RUnlock()
+	records := make(map[string]ID, len(db._ans))
+	for alias, identifier := range db._ans {
+		records[alias] = identifier
+	}
+	return records
+}
+
+//-------- LoginTable
+
+func (db *MemoryStorage) GetLoginCommand(user ID) LoginCommand {
+	db._lock.RLock()
+	defer db._lock.RUnlock()
+	return db._loginCommands[user]
+}
+
+func (db *MemoryStorage) GetLoginMessage(user ID) ReliableMessage {
+	db._lock.RLock()
+	defer db._lock.RUnlock()
+	return db._loginMessages[user]
+}
+
+func (db *MemoryStorage) SaveLoginCommandMessage(cmd LoginCommand, msg ReliableMessage) bool {
+	db._lock.Lock()
+	defer db._lock.Unlock()
+	// 1. verify sender ID
+	identifier := cmd.ID()
+	if msg.Sender().Equal(identifier) == false {
+		return false
+	}
+	// 2. check last login time
+	old := db._loginCommands[identifier]
+	if old != nil && cmd.Time().Unix() <= old.Time().Unix() {
+		// expired command, drop it
+		return false
+	}
+	// 3. cache them
+	db._loginCommands[identifier] = cmd
+	db._loginMessages[identifier] = msg
+	return true
+}
+
+//-------- UserTable
+
+func (db *MemoryStorage) AllUsers() []ID {
+	db._lock.RLock()
+	defer db._lock.RUnlock()
+	return copyIDList(db._users)
+}
+
+func (db *MemoryStorage) AddUser(user ID) bool {
+	db._lock.Lock()
+	defer db._lock.Unlock()
+	if containsID(db._users, user) {
+		return false
+	}
+	users := make([]ID, 0, len(db._users)+1)
+	users = append(users, user)
+	db._users = append(users, db._users...)
+	return true
+}
+
+func (db *MemoryStorage) RemoveUser(user ID) bool {
+	db._lock.Lock()
+	defer db._lock.Unlock()
+	users, ok := removeID(db._users, user)
+	db._users = users
+	return ok
+}
+
+func (db *MemoryStorage) SetCurrentUser(user ID) {
+	db._lock.Lock()
+	defer db._lock.Unlock()
+	users, _ := removeID(db._users, user)
+	db._users = append([]ID{user}, users...)
+}
+
+func (db *MemoryStorage) GetCurrentUser() ID {
+	db._lock.RLock()
+	defer db._lock.RUnlock()
+	if len(db._users) > 0 {
+		return db._users[0]
+	} else {
+		return nil
+	}
+}
+
+//-------- ContactTable
+
+func (db *MemoryStorage) GetContacts(user ID) []ID {
+	db._lock.RLock()
+	defer db._lock.RUnlock()
+	return copyIDList(db._contacts[user])
+}
+
+func (db *MemoryStorage) AddContact(contact ID, user ID) bool {
+	db._lock.Lock()
+	defer db._lock.Unlock()
+	arr := db._contacts[user]
+	if containsID(arr, contact) {
+		// duplicated
+		return false
+	}
+	db._contacts[user] = append(arr, contact)
+	return true
+}
+
+func (db *MemoryStorage) RemoveContact(contact ID, user ID) bool {
+	db._lock.Lock()
+	defer db._lock.Unlock()
+	arr, ok := removeID(db._contacts[user], contact)
+	db._contacts[user] = arr
+	return ok
+}
+
+func (db *MemoryStorage) SaveContacts(contacts []ID, user ID) bool {
+	db._lock.Lock()
+	defer db._lock.Unlock()
+	db._contacts[user] = contacts
+	return true
+}
+
+//-------- GroupTable
+
+func (db *MemoryStorage) GetFounder(group ID) ID {
+	db._lock.RLock()
+	defer db._lock.RUnlock()
+	return db._founders[group]
+}
+
+func (db *MemoryStorage) GetOwner(group ID) ID {
+	db._lock.RLock()
+	defer db._lock.RUnlock()
+	return db._owners[group]
+}
+
+func (db *MemoryStorage) GetMembers(group ID) []ID {
+	db._lock.RLock()
+	defer db._lock.RUnlock()
+	return copyIDList(db._members[group])
+}
+
+func (db *MemoryStorage) GetAssistants(group ID) []ID {
+	db._lock.RLock()
+	defer db._lock.RUnlock()
+	return copyIDList(db._assistants[group])
+}
+
+func (db *MemoryStorage) AddMember(member ID, group ID) bool {
+	db._lock.Lock()
+	defer db._lock.Unlock()
+	arr := db._members[group]
+	if containsID(arr, member) {
+		// duplicated
+		return false
+	}
+	db._members[group] = append(arr, member)
+	return true
+}
+
+func (db *MemoryStorage) RemoveMember(member ID, group ID) bool {
+	db._lock.Lock()
+	defer db._lock.Unlock()
+	arr, ok := removeID(db._members[group], member)
+	db._members[group] = arr
+	return ok
+}
+
+func (db *MemoryStorage) SaveMembers(members []ID, group ID) bool {
+	db._lock.Lock()
+	defer db._lock.Unlock()
+	db._members[group] = members
+	return true
+}
+
+func (db *MemoryStorage) SaveFounder(founder ID, group ID) bool {
+	db._lock.Lock()
+	defer db._lock.Unlock()
+	db._founders[group] = founder
+	return true
+}
+
+func (db *MemoryStorage) SaveOwner(owner ID, group ID) bool {
+	db._lock.Lock()
+	defer db._lock.Unlock()
+	db._owners[group] = owner
+	return true
+}
+
+func (db *MemoryStorage) SaveAssistants(bots []ID, group ID) bool {
+	db._lock.Lock()
+	defer db._lock.Unlock()
+	db._assistants[group] = bots
+	return true
+}
+
+func (db *MemoryStorage) RemoveGroup(group ID) bool {
+	db._lock.Lock()
+	defer db._lock.Unlock()
+	delete(db._members, group)
+	delete(db._founders, group)
+	delete(db._owners, group)
+	delete(db._assistants, group)
+	return true
+}
+
+// remove the ID from array, return a new array
+func removeID(array []ID, identifier ID) ([]ID, bool) {
+	results := make([]ID, 0, len(array))
+	for _, item := range array {
+		if identifier.Equal(item) == false {
+			results = append(results, item)
+		}
+	}
+	return results, len(results) < len(array)
+}
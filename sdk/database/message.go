@@ -0,0 +1,495 @@
+/* license: https://mit-license.org
+ * ==============================================================================
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2021 Albert Moky
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ * ==============================================================================
+ */
+package db
+
+import (
+	. "github.com/dimchat/demo-go/sdk/utils"
+	. "github.com/dimchat/dkd-go/protocol"
+	. "github.com/dimchat/mkm-go/format"
+	. "github.com/dimchat/mkm-go/protocol"
+	"sort"
+)
+
+const (
+	MessageStateDelivered = "delivered"  // receipt from station
+	MessageStateArrived = "arrived"      // receipt from receiver
+	MessageStateWithdrawn = "withdrawn"  // withdrawn by sender
+)
+
+//-------- ConversationTable
+
+func (db *Storage) NumberOfConversations() int {
+	db._chatsLock.Lock()
+	defer db._chatsLock.Unlock()
+	return len(getConversations(db))
+}
+
+func (db *Storage) ConversationAtIndex(index int) ID {
+	db._chatsLock.Lock()
+	defer db._chatsLock.Unlock()
+	array := getConversations(db)
+	if index < 0 || index >= len(array) {
+		return nil
+	}
+	return array[index].identifier
+}
+
+func (db *Storage) RemoveConversationAtIndex(index int) bool {
+	entity := db.ConversationAtIndex(index)
+	if entity == nil {
+		return false
+	}
+	return db.RemoveConversation(entity)
+}
+
+func (db *Storage) RemoveConversation(entity ID) bool {
+	db._locks.Lock(entity)
+	defer db._locks.Unlock(entity)
+	db._chatsLock.Lock()
+	defer db._chatsLock.Unlock()
+	array := getConversations(db)
+	pos := findConversation(array, entity)
+	if pos < 0 {
+		return false
+	}
+	db._conversations = append(array[:pos], array[pos+1:]...)
+	db._cacheLock.Lock()
+	delete(db._messages, entity)
+	db._cacheLock.Unlock()
+	path := messagesPath(db, entity)
+	if PathIsExist(path) && !PathRemove(path) {
+		db.error("Failed to remove messages: " + path)
+	}
+	return saveConversations(db, db._conversations)
+}
+
+//-------- MessageTable
+
+func (db *Storage) NumberOfMessages(entity ID) int {
+	db._locks.Lock(entity)
+	defer db._locks.Unlock(entity)
+	return len(getMessages(db, entity))
+}
+
+func (db *Storage) NumberOfUnreadMessages(entity ID) int {
+	db._chatsLock.Lock()
+	defer db._chatsLock.Unlock()
+	array := getConversations(db)
+	pos := findConversation(array, entity)
+	if pos < 0 {
+		return 0
+	}
+	return array[pos].unread
+}
+
+func (db *Storage) ClearUnreadMessages(entity ID) bool {
+	db._chatsLock.Lock()
+	defer db._chatsLock.Unlock()
+	array := getConversations(db)
+	pos := findConversation(array, entity)
+	if pos < 0 || array[pos].unread == 0 {
+		return false
+	}
+	array[pos].unread = 0
+	return saveConversations(db, array)
+}
+
+func (db *Storage) LastMessage(entity ID) InstantMessage {
+	return db.MessageAtIndex(0, entity)
+}
+
+func (db *Storage) LastReceivedMessage(user ID) InstantMessage {
+	// copy conversation IDs, so each chat box can be locked one by one
+	db._chatsLock.Lock()
+	array := getConversations(db)
+	chats := make([]ID, 0, len(array))
+	for _, chat := range array {
+		chats = append(chats, chat.identifier)
+	}
+	db._chatsLock.Unlock()
+	var last InstantMessage
+	for _, entity := range chats {
+		iMsg := lastReceivedMessage(db, entity, user)
+		if iMsg == nil {
+			continue
+		}
+		if last == nil || iMsg.Time().After(last.Time()) {
+			last = iMsg
+		}
+	}
+	return last
+}
+
+func lastReceivedMessage(db *Storage, entity ID, user ID) InstantMessage {
+	db._locks.Lock(entity)
+	defer db._locks.Unlock(entity)
+	messages := getMessages(db, entity)
+	pos := len(messages)
+	for pos > 0 {
+		pos--
+		iMsg := messages[pos]
+		if user.Equal(iMsg.Sender()) == false {
+			return iMsg
+		}
+	}
+	return nil
+}
+
+func (db *Storage) MessageAtIndex(index int, entity ID) InstantMessage {
+	db._locks.Lock(entity)
+	defer db._locks.Unlock(entity)
+	messages := getMessages(db, entity)
+	count := len(messages)
+	if index < 0 || index >= count {
+		return nil
+	}
+	// latest first
+	return messages[count-1-index]
+}
+
+func (db *Storage) InsertMessage(iMsg InstantMessage, entity ID) bool {
+	db._locks.Lock(entity)
+	defer db._locks.Unlock(entity)
+	messages := getMessages(db, entity)
+	if findMessage(messages, iMsg) >= 0 {
+		// duplicated
+		return false
+	}
+	if appendMessage(db, entity, iMsg) == false {
+		return false
+	}
+	setMessages(db, entity, append(messages, iMsg))
+	// update conversation
+	unread := 0
+	if containsID(db.AllUsers(), iMsg.Sender()) == false {
+		// received from others
+		unread = 1
+	}
+	return updateConversation(db, entity, iMsg.Time().Unix(), unread)
+}
+
+func (db *Storage) RemoveMessage(iMsg InstantMessage, entity ID) bool {
+	db._locks.Lock(entity)
+	defer db._locks.Unlock(entity)
+	messages := getMessages(db, entity)
+	pos := findMessage(messages, iMsg)
+	if pos < 0 {
+		return false
+	}
+	array := make([]InstantMessage, 0, len(messages))
+	array = append(array, messages[:pos]...)
+	array = append(array, messages[pos+1:]...)
+	setMessages(db, entity, array)
+	if saveMessages(db, entity, array) == false {
+		return false
+	}
+	return refreshConversation(db, entity, array)
+}
+
+func (db *Storage) WithdrawMessage(iMsg InstantMessage, entity ID) bool {
+	db._locks.Lock(entity)
+	defer db._locks.Unlock(entity)
+	messages := getMessages(db, entity)
+	pos := findMessage(messages, iMsg)
+	if pos < 0 {
+		return false
+	}
+	// only mark it here, the sender should notify the receiver by itself
+	messages[pos].Set("state", MessageStateWithdrawn)
+	return saveMessages(db, entity, messages)
+}
+
+func (db *Storage) SaveReceipt(iMsg InstantMessage, entity ID) bool {
+	receipt := iMsg.Content()
+	db._locks.Lock(entity)
+	defer db._locks.Unlock(entity)
+	messages := getMessages(db, entity)
+	pos := len(messages)
+	for pos > 0 {
+		pos--
+		target := messages[pos]
+		if matchReceipt(target, receipt) == false {
+			continue
+		}
+		applyReceipt(target, iMsg.Sender())
+		return saveMessages(db, entity, messages)
+	}
+	// target message not found
+	return false
+}
+
+// check whether the receipt is responded for the target message,
+// match by signature, or by the original envelope & serial number
+func matchReceipt(target InstantMessage, receipt Content) bool {
+	signature, _ := receipt.Get("signature").(string)
+	if signature != "" && signature == target.Get("signature") {
+		return true
+	}
+	sender := IDParse(receipt.Get("sender"))
+	if sender == nil || sender.Equal(target.Sender()) == false {
+		return false
+	}
+	receiver := IDParse(receipt.Get("receiver"))
+	if receiver != nil && receiver.Equal(target.Receiver()) == false {
+		return false
+	}
+	sn, ok := ToInt64(receipt.Get("sn"))
+	return ok && uint64(sn) == target.Content().SN()
+}
+
+// update message state with receipt sender
+func applyReceipt(target InstantMessage, sender ID) {
+	if sender.Type() == STATION {
+		if target.Get("state") == nil {
+			target.Set("state", MessageStateDelivered)
+		}
+	} else if target.Get("state") != MessageStateWithdrawn {
+		target.Set("state", MessageStateArrived)
+	}
+	// add trace
+	traces, _ := target.Get("traces").([]interface{})
+	target.Set("traces", append(traces, sender.String()))
+}
+
+func findMessage(messages []InstantMessage, iMsg InstantMessage) int {
+	signature := iMsg.Get("signature")
+	sender := iMsg.Sender()
+	sn := iMsg.Content().SN()
+	for index, item := range messages {
+		if signature != nil {
+			if signature == item.Get("signature") {
+				return index
+			}
+		} else if sender.Equal(item.Sender()) && sn == item.Content().SN() {
+			return index
+		}
+	}
+	return -1
+}
+
+/**
+ *  Messages file for Conversation
+ *  ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+ *
+ *  file path: '.dim/messages/{ADDRESS}/messages.js'
+ *
+ *  one message per line, new messages are appended to the end
+ */
+
+func messagesPath(db *Storage, entity ID) string {
+	return PathJoin(db.Root(), "messages", entity.Address().String(), "messages.js")
+}
+
+func loadMessages(db *Storage, entity ID) []InstantMessage {
+	path := messagesPath(db, entity)
+	db.log("Loading messages: " + path)
+	messages := make([]InstantMessage, 0)
+	db.readLines(path, func(line string) bool {
+		info := decodeJSONMap(line)
+		if info == nil {
+			// truncated by a crash while appending?
+			return false
+		}
+		iMsg := InstantMessageParse(info)
+		if iMsg == nil {
+			return false
+		}
+		messages = append(messages, iMsg)
+		return true
+	})
+	return messages
+}
+
+func appendMessage(db *Storage, entity ID, iMsg InstantMessage) bool {
+	path := messagesPath(db, entity)
+	db.log("Appending message: " + path)
+	return db.appendText(path, JSONEncodeMap(iMsg.Map()) + "\n")
+}
+
+func saveMessages(db *Storage, entity ID, messages []InstantMessage) bool {
+	text := ""
+	for _, item := range messages {
+		text += JSONEncodeMap(item.Map()) + "\n"
+	}
+	path := messagesPath(db, entity)
+	db.log("Saving messages: " + path)
+	return db.writeText(path, text)
+}
+
+func getMessages(db *Storage, entity ID) []InstantMessage {
+	db._cacheLock.RLock()
+	messages := db._messages[entity]
+	db._cacheLock.RUnlock()
+	if messages == nil {
+		messages = loadMessages(db, entity)
+		setMessages(db, entity, messages)
+	}
+	return messages
+}
+
+func setMessages(db *Storage, entity ID, messages []InstantMessage) {
+	db._cacheLock.Lock()
+	db._messages[entity] = messages
+	db._cacheLock.Unlock()
+}
+
+/**
+ *  Conversations Index
+ *  ~~~~~~~~~~~~~~~~~~~
+ *
+ *  file path: '.dim/messages/conversations.js'
+ *
+ *  format: [
+ *      {ID: "{ENTITY_ID}", time: 123, unread: 0},
+ *  ]
+ *  sorted by last message time, latest first
+ */
+
+type conversation struct {
+	identifier ID
+	time int64
+	unread int
+}
+
+func conversationsPath(db *Storage) string {
+	return PathJoin(db.Root(), "messages", "conversations.js")
+}
+
+func loadConversations(db *Storage) []*conversation {
+	path := conversationsPath(db)
+	db.log("Loading conversations: " + path)
+	array := make([]*conversation, 0)
+	list := db.readList(path)
+	for _, item := range list {
+		info, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		identifier := IDParse(info["ID"])
+		if identifier == nil {
+			continue
+		}
+		chat := new(conversation)
+		chat.identifier = identifier
+		if value, ok := info["time"].(float64); ok {
+			chat.time = int64(value)
+		}
+		if value, ok := info["unread"].(float64); ok {
+			chat.unread = int(value)
+		}
+		array = append(array, chat)
+	}
+	sortConversations(array)
+	return array
+}
+
+func saveConversations(db *Storage, array []*conversation) bool {
+	list := make([]interface{}, 0, len(array))
+	for _, chat := range array {
+		info := make(map[string]interface{})
+		info["ID"] = chat.identifier.String()
+		info["time"] = chat.time
+		info["unread"] = chat.unread
+		list = append(list, info)
+	}
+	path := conversationsPath(db)
+	db.log("Saving conversations: " + path)
+	return db.writeMap(path, list)
+}
+
+// NOTICE: caller must hold the conversations lock
+func getConversations(db *Storage) []*conversation {
+	if db._conversations == nil {
+		db._conversations = loadConversations(db)
+	}
+	return db._conversations
+}
+
+func updateConversation(db *Storage, entity ID, time int64, unread int) bool {
+	db._chatsLock.Lock()
+	defer db._chatsLock.Unlock()
+	array := getConversations(db)
+	pos := findConversation(array, entity)
+	var chat *conversation
+	if pos < 0 {
+		chat = new(conversation)
+		chat.identifier = entity
+		array = append(array, chat)
+	} else {
+		chat = array[pos]
+	}
+	if time > chat.time {
+		chat.time = time
+	}
+	chat.unread += unread
+	sortConversations(array)
+	db._conversations = array
+	return saveConversations(db, array)
+}
+
+// NOTICE: caller must hold the entity lock
+func refreshConversation(db *Storage, entity ID, messages []InstantMessage) bool {
+	db._chatsLock.Lock()
+	defer db._chatsLock.Unlock()
+	array := getConversations(db)
+	pos := findConversation(array, entity)
+	if pos < 0 {
+		return true
+	}
+	if len(messages) == 0 {
+		// no message left, remove the conversation from index
+		array = append(array[:pos], array[pos+1:]...)
+	} else {
+		chat := array[pos]
+		chat.time = 0
+		for _, item := range messages {
+			if item.Time().Unix() > chat.time {
+				chat.time = item.Time().Unix()
+			}
+		}
+		if chat.unread > len(messages) {
+			chat.unread = len(messages)
+		}
+		sortConversations(array)
+	}
+	db._conversations = array
+	return saveConversations(db, array)
+}
+
+func findConversation(array []*conversation, entity ID) int {
+	for index, item := range array {
+		if entity.Equal(item.identifier) {
+			return index
+		}
+	}
+	return -1
+}
+
+func sortConversations(array []*conversation) {
+	sort.SliceStable(array, func(i, j int) bool {
+		return array[i].time > array[j].time
+	})
+}
@@ -33,6 +33,8 @@ import (
 //-------- MetaTable
 
 func (db *Storage) SaveMeta(meta Meta, entity ID) bool {
+	db._locks.Lock(entity)
+	defer db._locks.Unlock(entity)
 	if cacheMeta(db, meta, entity) {
 		return saveMeta(db, meta, entity)
 	} else {
@@ -41,6 +43,8 @@ func (db *Storage) SaveMeta(meta Meta, entity ID) bool {
 }
 
 func (db *Storage) GetMeta(entity ID) Meta {
+	db._locks.Lock(entity)
+	defer db._locks.Unlock(entity)
 	return getMeta(db, entity)
 }
 
@@ -73,16 +77,17 @@ var emptyMeta = MetaGenerate(MKM, emptyPrivateKey, "empty")
 
 func getMeta(db *Storage, identifier ID) Meta {
 	// 1. try from memory cache
-	meta := db._metas[identifier]
+	value, _ := db._metas.Get(identifier)
+	meta, _ := value.(Meta)
 	if meta == nil {
 		// 2. try from local storage
 		meta = loadMeta(db, identifier)
 		if meta == nil {
 			// place an empty meta for cache
-			db._metas[identifier] = emptyMeta
+			db.cachePut(db._metas, identifier, emptyMeta, true)
 		} else {
 			// cache it
-			db._metas[identifier] = meta
+			db.cachePut(db._metas, identifier, meta, false)
 		}
 	} else if meta == emptyMeta {
 		meta = nil
@@ -94,7 +99,7 @@ func cacheMeta(db *Storage, meta Meta, identifier ID) bool {
 	// 1. verify meta with ID
 	if MetaMatchID(meta, identifier) {
 		// 2. cache it
-		db._metas[identifier] = meta
+		db.cachePut(db._metas, identifier, meta, false)
 		return true
 	} else {
 		return false
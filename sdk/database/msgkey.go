@@ -0,0 +1,111 @@
+/* license: https://mit-license.org
+ * ==============================================================================
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2021 Albert Moky
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ * ==============================================================================
+ */
+package db
+
+import (
+	. "github.com/dimchat/demo-go/sdk/utils"
+	. "github.com/dimchat/mkm-go/crypto"
+	. "github.com/dimchat/mkm-go/format"
+	. "github.com/dimchat/mkm-go/protocol"
+)
+
+//-------- MsgKeyTable
+
+func (db *Storage) GetKey(from ID, to ID) SymmetricKey {
+	db._locks.Lock(from)
+	defer db._locks.Unlock(from)
+	table := getMsgKeys(db, from)
+	return table[to]
+}
+
+func (db *Storage) SaveKey(from ID, to ID, key SymmetricKey) bool {
+	db._locks.Lock(from)
+	defer db._locks.Unlock(from)
+	table := getMsgKeys(db, from)
+	// NOTICE: the inner table is only touched with the sender locked
+	table[to] = key
+	return saveMsgKeys(db, from, table)
+}
+
+/**
+ *  Message Keys for Sender
+ *  ~~~~~~~~~~~~~~~~~~~~~~~
+ *
+ *  file path: '.dim/private/{SENDER_ADDRESS}/msg_keys.js'
+ *
+ *  format: {
+ *      "{RECEIVER_ID}": {algorithm: "AES", data: "..."},
+ *  }
+ */
+
+func msgKeysPath(db *Storage, sender ID) string {
+	return PathJoin(db.Root(), "private", sender.Address().String(), "msg_keys.js")
+}
+
+func loadMsgKeys(db *Storage, sender ID) map[ID]SymmetricKey {
+	table := make(map[ID]SymmetricKey)
+	path := msgKeysPath(db, sender)
+	db.log("Loading message keys: " + path)
+	dict := db.readSecretMap(path)
+	for receiver, item := range dict {
+		to := IDParse(receiver)
+		key := SymmetricKeyParse(item)
+		if to == nil || key == nil {
+			db.error("Invalid message key: " + receiver)
+			continue
+		}
+		table[to] = key
+	}
+	return table
+}
+
+func saveMsgKeys(db *Storage, sender ID, table map[ID]SymmetricKey) bool {
+	dict := make(map[string]interface{})
+	for receiver, key := range table {
+		dict[receiver.String()] = key.Map()
+	}
+	path := msgKeysPath(db, sender)
+	db.log("Saving message keys: " + path)
+	json := JSONEncodeMap(dict)
+	data := UTF8Encode(json)
+	return db.writeSecret(path, data)
+}
+
+func getMsgKeys(db *Storage, sender ID) map[ID]SymmetricKey {
+	// 1. try from memory cache
+	db._cacheLock.RLock()
+	table := db._msgKeys[sender]
+	db._cacheLock.RUnlock()
+	if table == nil {
+		// 2. try from local storage
+		table = loadMsgKeys(db, sender)
+		// 3. cache them
+		db._cacheLock.Lock()
+		db._msgKeys[sender] = table
+		db._cacheLock.Unlock()
+	}
+	return table
+}
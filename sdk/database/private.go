@@ -36,6 +36,8 @@ import (
 //-------- PrivateKeyTable
 
 func (db *Storage) SavePrivateKey(user ID, key PrivateKey, keyType string, sign bool, decrypt bool) bool {
+	db._locks.Lock(user)
+	defer db._locks.Unlock(user)
 	if keyType == META_KEY {
 		if cacheIdentityKey(db, user, key) {
 			return saveIdentityKey(db, user, key)
@@ -43,7 +45,7 @@ func (db *Storage) SavePrivateKey(user ID, key PrivateKey, keyType string, sign
 			return false
 		}
 	} else {
-		if cacheCommunicationKey(db, user, key) {
+		if cacheCommunicationKey(db, user, key, sign) {
 			keys := getCommunicationKeys(db, user)
 			return saveCommunicationKeys(db, user, keys)
 		} else {
@@ -52,11 +54,36 @@ func (db *Storage) SavePrivateKey(user ID, key PrivateKey, keyType string, sign
 	}
 }
 
+func (db *Storage) RemovePrivateKey(user ID, key PrivateKey, keyType string) bool {
+	if keyType == META_KEY {
+		// identity key won't change
+		return false
+	}
+	db._locks.Lock(user)
+	defer db._locks.Unlock(user)
+	keys := getCommunicationKeys(db, user)
+	index := findKey(keys, key)
+	if index < 0 {
+		return false
+	}
+	keys = removeKey(keys, index)
+	db._cacheLock.Lock()
+	db._communicationKeys[user] = keys
+	// reset decryption keys
+	delete(db._decryptionKeys, user)
+	db._cacheLock.Unlock()
+	return saveCommunicationKeys(db, user, keys)
+}
+
 func (db *Storage) GetPrivateKeysForDecryption(user ID) []DecryptKey {
+	db._locks.Lock(user)
+	defer db._locks.Unlock(user)
 	return getDecryptionKeys(db, user)
 }
 
 func (db *Storage) GetPrivateKeyForSignature(user ID) PrivateKey {
+	db._locks.Lock(user)
+	defer db._locks.Unlock(user)
 	keys := getCommunicationKeys(db, user)
 	if len(keys) > 0 {
 		// sign message with communication key
@@ -68,6 +95,8 @@ func (db *Storage) GetPrivateKeyForSignature(user ID) PrivateKey {
 }
 
 func (db *Storage) GetPrivateKeyForVisaSignature(user ID) PrivateKey {
+	db._locks.Lock(user)
+	defer db._locks.Unlock(user)
 	return getIdentityKey(db, user)
 }
 
@@ -93,31 +122,28 @@ func communicationKeysPath(db *Storage, identifier ID) string {
 func loadIdentityKey(db *Storage, identifier ID) PrivateKey {
 	path := identityKeyPath(db, identifier)
 	db.log("Loading identity key: " + path)
-	data := db.readSecret(path)
-	if data == nil {
+	info := db.readSecretMap(path)
+	if info == nil {
 		return nil
-	} else {
-		json := UTF8Decode(data)
-		dict := JSONDecodeMap(json)
-		return PrivateKeyParse(dict)
 	}
+	key := PrivateKeyParse(info)
+	if key == nil {
+		db.quarantine(path)
+	}
+	return key
 }
 func loadCommunicationKeys(db *Storage, identifier ID) []PrivateKey {
 	keys := make([]PrivateKey, 0, 1)
 	path := communicationKeysPath(db, identifier)
 	db.log("Loading communication keys: " + path)
-	data := db.readSecret(path)
-	if data != nil {
-		json := UTF8Decode(data)
-		arr := JSONDecodeList(json)
-		for _, item := range arr {
-			k := PrivateKeyParse(item)
-			if k == nil {
-				panic(item)
-			} else {
-				keys = append(keys, k)
-			}
+	arr := db.readSecretList(path)
+	for _, item := range arr {
+		k := PrivateKeyParse(item)
+		if k == nil {
+			db.error("Invalid communication key: " + path)
+			continue
 		}
+		keys = append(keys, k)
 	}
 	return keys
 }
@@ -147,16 +173,17 @@ var emptyPrivateKey = PrivateKeyGenerate(ECC)
 
 func getIdentityKey(db *Storage, identifier ID) PrivateKey {
 	// 1. try from memory cache
-	key := db._identityKeys[identifier]
+	value, _ := db._identityKeys.Get(identifier)
+	key, _ := value.(PrivateKey)
 	if key == nil {
 		// 2. try from local storage
 		key = loadIdentityKey(db, identifier)
 		if key == nil {
 			// place an empty key for cache
-			db._identityKeys[identifier] = emptyPrivateKey
+			db.cachePut(db._identityKeys, identifier, emptyPrivateKey, true)
 		} else {
 			// cache it
-			db._identityKeys[identifier] = key
+			db.cachePut(db._identityKeys, identifier, key, false)
 		}
 	} else if key == emptyPrivateKey {
 		db.error("Private key not found: " + identifier.String())
@@ -167,18 +194,24 @@ func getIdentityKey(db *Storage, identifier ID) PrivateKey {
 
 func getCommunicationKeys(db *Storage, identifier ID) []PrivateKey {
 	// 1. try from memory cache
+	db._cacheLock.RLock()
 	keys := db._communicationKeys[identifier]
+	db._cacheLock.RUnlock()
 	if keys == nil {
 		// 2. try from local storage
 		keys = loadCommunicationKeys(db, identifier)
 		// 3. cache them
+		db._cacheLock.Lock()
 		db._communicationKeys[identifier] = keys
+		db._cacheLock.Unlock()
 	}
 	return keys
 }
 func getDecryptionKeys(db *Storage, identifier ID) []DecryptKey {
 	// 1. try from memory cache
+	db._cacheLock.RLock()
 	keys := db._decryptionKeys[identifier]
+	db._cacheLock.RUnlock()
 	if keys == nil || len(keys) == 0 {
 		var decKey DecryptKey
 		var ok bool
@@ -198,7 +231,9 @@ func getDecryptionKeys(db *Storage, identifier ID) []DecryptKey {
 			keys = append(keys, decKey)
 		}
 		// 4. cache them
+		db._cacheLock.Lock()
 		db._decryptionKeys[identifier] = keys
+		db._cacheLock.Unlock()
 	}
 	return keys
 }
@@ -206,7 +241,12 @@ func getDecryptionKeys(db *Storage, identifier ID) []DecryptKey {
 func cacheIdentityKey(db *Storage, identifier ID, key PrivateKey) bool {
 	old := getIdentityKey(db, identifier)
 	if old == nil {
-		db._identityKeys[identifier] = key
+		if PathIsExist(identityKeyPath(db, identifier)) {
+			// secret file exists but cannot be read, don't overwrite it
+			db.error("Identity key file not readable: " + identifier.String())
+			return false
+		}
+		db.cachePut(db._identityKeys, identifier, key, false)
 		return true
 	} else {
 		// identity key won't change
@@ -214,20 +254,16 @@ func cacheIdentityKey(db *Storage, identifier ID, key PrivateKey) bool {
 	}
 }
 
-func cacheCommunicationKey(db *Storage, identifier ID, key PrivateKey) bool {
-	keys := getCommunicationKeys(db, identifier)
-	index := findKey(keys, key)
-	if index == 0 {
-		return false                   // nothing changed
-	} else if index > 0 {
-		keys = removeKey(keys, index)  // move to the front
-	} else if len(keys) > 2 {
-		keys = keys[:2]                // keep only last three records
+func cacheCommunicationKey(db *Storage, identifier ID, key PrivateKey, sign bool) bool {
+	keys := updateKeys(getCommunicationKeys(db, identifier), key, sign)
+	if keys == nil {
+		return false
 	}
-	keys = insertKey(keys, key)
+	db._cacheLock.Lock()
 	db._communicationKeys[identifier] = keys
 	// reset decryption keys
 	delete(db._decryptionKeys, identifier)
+	db._cacheLock.Unlock()
 	return true
 }
 
@@ -239,11 +275,33 @@ func findKey(keys []PrivateKey, key PrivateKey) int {
 	}
 	return -1
 }
+// return a new array, the cached one may be read by others
 func removeKey(keys []PrivateKey, index int) []PrivateKey {
-	return append(keys[:index], keys[index+1:]...)
+	arr := make([]PrivateKey, 0, len(keys))
+	arr = append(arr, keys[:index]...)
+	return append(arr, keys[index+1:]...)
 }
-func insertKey(keys []PrivateKey, key PrivateKey) []PrivateKey {
+func insertKey(keys []PrivateKey, key PrivateKey, index int) []PrivateKey {
 	arr := make([]PrivateKey, 0, len(keys) + 1)
+	arr = append(arr, keys[:index]...)
 	arr = append(arr, key)
-	return append(arr, keys...)
+	return append(arr, keys[index:]...)
+}
+
+// the first communication key is used for signature;
+// a key not for signing is put next to it, e.g. before its visa published.
+// return nil when nothing changed
+func updateKeys(keys []PrivateKey, key PrivateKey, sign bool) []PrivateKey {
+	index := findKey(keys, key)
+	if index == 0 || (index > 0 && !sign) {
+		return nil                     // nothing changed
+	} else if index > 0 {
+		keys = removeKey(keys, index)  // move to the front
+	} else if len(keys) > 2 {
+		keys = keys[:2]                // keep only last three records
+	}
+	if sign || len(keys) == 0 {
+		return insertKey(keys, key, 0)
+	}
+	return insertKey(keys, key, 1)
 }
@@ -0,0 +1,431 @@
+/* license: https://mit-license.org
+ * ==============================================================================
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2021 Albert Moky
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ * ==============================================================================
+ */
+package db
+
+import (
+	"fmt"
+	. "github.com/dimchat/demo-go/sdk/client/db"
+	. "github.com/dimchat/demo-go/sdk/utils"
+	. "github.com/dimchat/mkm-go/protocol"
+	"path/filepath"
+	"strings"
+)
+
+/**
+ *  Database with service providers imported from config file
+ */
+type ProvidersConfig interface {
+	ProviderTable
+
+	/**
+	 *  Set config file for importing service providers & stations
+	 *
+	 * @param path - config file
+	 */
+	SetProvidersConfig(path string)
+}
+
+//-------- ProviderTable
+
+func (db *Storage) GetProviders() []*ProviderInfo {
+	db._providersLock.Lock()
+	defer db._providersLock.Unlock()
+	// return copies, the cached ones will be updated with lock
+	array := getProviders(db)
+	providers := make([]*ProviderInfo, 0, len(array))
+	for _, item := range array {
+		info := *item
+		providers = append(providers, &info)
+	}
+	return providers
+}
+
+func (db *Storage) AddProvider(identifier ID, name string, url string, chosen bool) bool {
+	db._providersLock.Lock()
+	defer db._providersLock.Unlock()
+	array := getProviders(db)
+	if findProvider(array, identifier) >= 0 {
+		// duplicated
+		return false
+	}
+	db._providers = append(array, NewProviderInfo(identifier, name, url, chosen))
+	chooseProvider(db._providers, identifier, chosen)
+	return saveProviders(db)
+}
+
+func (db *Storage) UpdateProvider(identifier ID, name string, url string, chosen bool) bool {
+	db._providersLock.Lock()
+	defer db._providersLock.Unlock()
+	array := getProviders(db)
+	pos := findProvider(array, identifier)
+	if pos < 0 {
+		return false
+	}
+	info := array[pos]
+	info.Name = name
+	info.URL = url
+	info.Chosen = chosen
+	chooseProvider(array, identifier, chosen)
+	return saveProviders(db)
+}
+
+func (db *Storage) RemoveProvider(identifier ID) bool {
+	db._providersLock.Lock()
+	defer db._providersLock.Unlock()
+	array := getProviders(db)
+	pos := findProvider(array, identifier)
+	if pos < 0 {
+		return false
+	}
+	db._providers = append(array[:pos], array[pos+1:]...)
+	delete(db._stations, identifier)
+	chooseProvider(db._providers, nil, false)
+	return saveProviders(db)
+}
+
+//-------- StationTable
+
+func (db *Storage) GetStations(sp ID) []*StationInfo {
+	db._providersLock.Lock()
+	defer db._providersLock.Unlock()
+	getProviders(db)
+	// return copies, the cached ones will be updated with lock
+	array := db._stations[sp]
+	stations := make([]*StationInfo, 0, len(array))
+	for _, item := range array {
+		info := *item
+		stations = append(stations, &info)
+	}
+	return stations
+}
+
+func (db *Storage) AddStation(sp ID, station ID, host string, port uint16, name string, chosen bool) bool {
+	db._providersLock.Lock()
+	defer db._providersLock.Unlock()
+	array := getProviders(db)
+	if findProvider(array, sp) < 0 {
+		// provider not found
+		return false
+	}
+	stations := db._stations[sp]
+	if findStation(stations, station) >= 0 {
+		// duplicated
+		return false
+	}
+	stations = append(stations, NewStationInfo(station, name, host, port, chosen))
+	chooseStation(stations, station, chosen)
+	db._stations[sp] = stations
+	return saveProviders(db)
+}
+
+func (db *Storage) UpdateStation(sp ID, station ID, host string, port uint16, name string, chosen bool) bool {
+	db._providersLock.Lock()
+	defer db._providersLock.Unlock()
+	getProviders(db)
+	stations := db._stations[sp]
+	pos := findStation(stations, station)
+	if pos < 0 {
+		return false
+	}
+	info := stations[pos]
+	info.Host = host
+	info.Port = port
+	info.Name = name
+	info.Chosen = chosen
+	chooseStation(stations, station, chosen)
+	return saveProviders(db)
+}
+
+func (db *Storage) ChooseStation(sp ID, station ID) bool {
+	db._providersLock.Lock()
+	defer db._providersLock.Unlock()
+	getProviders(db)
+	stations := db._stations[sp]
+	pos := findStation(stations, station)
+	if pos < 0 {
+		return false
+	}
+	chooseStation(stations, station, true)
+	return saveProviders(db)
+}
+
+func (db *Storage) RemoveStation(sp ID, station ID) bool {
+	db._providersLock.Lock()
+	defer db._providersLock.Unlock()
+	getProviders(db)
+	stations := db._stations[sp]
+	pos := findStation(stations, station)
+	if pos < 0 {
+		return false
+	}
+	stations = append(stations[:pos], stations[pos+1:]...)
+	chooseStation(stations, nil, false)
+	db._stations[sp] = stations
+	return saveProviders(db)
+}
+
+func (db *Storage) RemoveStations(sp ID) bool {
+	db._providersLock.Lock()
+	defer db._providersLock.Unlock()
+	getProviders(db)
+	if len(db._stations[sp]) == 0 {
+		return false
+	}
+	delete(db._stations, sp)
+	return saveProviders(db)
+}
+
+/**
+ *  Import service providers & stations from config file,
+ *  only when no provider exists in local storage (first start)
+ *
+ *  NOTICE: only JSON is supported, convert YAML config to JSON before importing
+ *
+ * @param path - JSON file with the same format as 'providers.js'
+ * @return false on nothing imported
+ */
+func (db *Storage) ImportProviders(path string) bool {
+	db._providersLock.Lock()
+	defer db._providersLock.Unlock()
+	if len(getProviders(db)) > 0 {
+		// already imported
+		return false
+	}
+	return importProviders(db, path)
+}
+
+/**
+ *  Set config file for importing service providers & stations,
+ *  it will be imported automatically when the provider table is empty,
+ *  and retried on next access if failed
+ *
+ * @param path - JSON file with the same format as 'providers.js'
+ */
+func (db *Storage) SetProvidersConfig(path string) {
+	db._providersLock.Lock()
+	defer db._providersLock.Unlock()
+	db._providersConfig = path
+	if len(db._providers) == 0 {
+		// reload & import later
+		db._providers = nil
+	}
+}
+
+// NOTICE: caller must hold the providers lock
+func importProviders(db *Storage, path string) bool {
+	if ext := strings.ToLower(filepath.Ext(path)); ext == ".yaml" || ext == ".yml" {
+		db.error("YAML not supported, please convert providers config to JSON: " + path)
+		return false
+	}
+	list, ok := ReadJSONFile(path).([]interface{})
+	if !ok || len(list) == 0 {
+		db.error("Invalid providers config: " + path)
+		return false
+	}
+	db.log("Importing providers: " + path)
+	providers, stations := parseProviders(db, list)
+	if len(providers) == 0 {
+		db.error("No valid provider in config: " + path)
+		return false
+	}
+	db._providers, db._stations = providers, stations
+	return saveProviders(db)
+}
+
+/**
+ *  Service Providers & Stations
+ *  ~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+ *
+ *  file path: '.dim/protected/providers.js'
+ *
+ *  format: [
+ *      {
+ *          ID       : "{SP_ID}",
+ *          name     : "{SP_NAME}",
+ *          URL      : "{ENTRANCE_URL}",
+ *          chosen   : true,
+ *          stations : [
+ *              {ID: "{STATION_ID}", name: "", host: "127.0.0.1", port: 9394, chosen: true},
+ *          ]
+ *      }
+ *  ]
+ */
+
+func providersPath(db *Storage) string {
+	return PathJoin(db.Root(), "protected", "providers.js")
+}
+
+func loadProviders(db *Storage) ([]*ProviderInfo, map[ID][]*StationInfo) {
+	path := providersPath(db)
+	db.log("Loading providers: " + path)
+	list := db.readList(path)
+	return parseProviders(db, list)
+}
+
+func parseProviders(db *Storage, list []interface{}) ([]*ProviderInfo, map[ID][]*StationInfo) {
+	providers := make([]*ProviderInfo, 0, len(list))
+	stations := make(map[ID][]*StationInfo)
+	for _, item := range list {
+		info, _ := item.(map[string]interface{})
+		sp := IDParse(info["ID"])
+		if sp == nil {
+			db.error("Invalid provider info")
+			continue
+		}
+		name, _ := info["name"].(string)
+		url, _ := info["URL"].(string)
+		chosen, _ := info["chosen"].(bool)
+		providers = append(providers, NewProviderInfo(sp, name, url, chosen))
+		// stations of this sp
+		array, _ := info["stations"].([]interface{})
+		for _, value := range array {
+			dict, _ := value.(map[string]interface{})
+			sid := IDParse(dict["ID"])
+			if sid == nil {
+				db.error("Invalid station info for sp: " + sp.String())
+				continue
+			}
+			name, _ = dict["name"].(string)
+			host, _ := dict["host"].(string)
+			port, _ := dict["port"].(float64)
+			if port < 1 || port > 65535 || port != float64(int(port)) {
+				db.error(fmt.Sprintf("Invalid station port: %v, %s", dict["port"], sid))
+				continue
+			}
+			chosen, _ = dict["chosen"].(bool)
+			stations[sp] = append(stations[sp], NewStationInfo(sid, name, host, uint16(port), chosen))
+		}
+		chooseStation(stations[sp], nil, false)
+	}
+	chooseProvider(providers, nil, false)
+	return providers, stations
+}
+
+func saveProviders(db *Storage) bool {
+	list := make([]interface{}, 0, len(db._providers))
+	for _, sp := range db._providers {
+		array := make([]interface{}, 0)
+		for _, station := range db._stations[sp.ID] {
+			array = append(array, map[string]interface{}{
+				"ID": station.ID.String(),
+				"name": station.Name,
+				"host": station.Host,
+				"port": station.Port,
+				"chosen": station.Chosen,
+			})
+		}
+		list = append(list, map[string]interface{}{
+			"ID": sp.ID.String(),
+			"name": sp.Name,
+			"URL": sp.URL,
+			"chosen": sp.Chosen,
+			"stations": array,
+		})
+	}
+	path := providersPath(db)
+	db.log("Saving providers: " + path)
+	return db.writeMap(path, list)
+}
+
+// NOTICE: caller must hold the providers lock
+func getProviders(db *Storage) []*ProviderInfo {
+	if db._providers == nil {
+		db._providers, db._stations = loadProviders(db)
+		if len(db._providers) == 0 && db._providersConfig != "" {
+			// first start
+			if importProviders(db, db._providersConfig) == false {
+				// try again on next access
+				db._providers = nil
+				return nil
+			}
+		}
+	}
+	return db._providers
+}
+
+func findProvider(array []*ProviderInfo, identifier ID) int {
+	for index, item := range array {
+		if identifier.Equal(item.ID) {
+			return index
+		}
+	}
+	return -1
+}
+
+func findStation(array []*StationInfo, identifier ID) int {
+	for index, item := range array {
+		if identifier.Equal(item.ID) {
+			return index
+		}
+	}
+	return -1
+}
+
+// make sure exactly one provider chosen
+func chooseProvider(array []*ProviderInfo, identifier ID, chosen bool) {
+	if identifier != nil && chosen {
+		// unset others
+		for _, item := range array {
+			item.Chosen = identifier.Equal(item.ID)
+		}
+		return
+	}
+	found := false
+	for _, item := range array {
+		if item.Chosen {
+			if found {
+				item.Chosen = false
+			}
+			found = true
+		}
+	}
+	if !found && len(array) > 0 {
+		array[0].Chosen = true
+	}
+}
+
+// make sure exactly one station chosen for the provider
+func chooseStation(array []*StationInfo, identifier ID, chosen bool) {
+	if identifier != nil && chosen {
+		// unset others
+		for _, item := range array {
+			item.Chosen = identifier.Equal(item.ID)
+		}
+		return
+	}
+	found := false
+	for _, item := range array {
+		if item.Chosen {
+			if found {
+				item.Chosen = false
+			}
+			found = true
+		}
+	}
+	if !found && len(array) > 0 {
+		array[0].Chosen = true
+	}
+}
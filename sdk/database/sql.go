@@ -0,0 +1,487 @@
+/* license: https://mit-license.org
+ * ==============================================================================
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2021 Albert Moky
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ * ==============================================================================
+ */
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	. "github.com/dimchat/demo-go/sdk/utils"
+	. "github.com/dimchat/mkm-go/crypto"
+	. "github.com/dimchat/mkm-go/format"
+	. "github.com/dimchat/sdk-go/plugins/crypto"
+	"strings"
+	"sync"
+)
+
+/**
+ *  Schema Migrations
+ *  ~~~~~~~~~~~~~~~~~
+ *
+ *  Each step upgrades the schema to the next version,
+ *  append new steps to the end, never modify the old ones.
+ *
+ *  NOTICE: statements are written with '?' placeholders,
+ *          which works for SQLite & MySQL drivers.
+ *          MySQL commits DDL statements implicitly, so a failed step
+ *          may be partially applied; when running it again, indexes
+ *          and columns already exist will be skipped.
+ */
+var sqlMigrations = [][]string{
+	// version 1
+	{
+		"CREATE TABLE IF NOT EXISTS t_private_key (id VARCHAR(128) NOT NULL, type CHAR(1) NOT NULL, data BLOB NOT NULL, PRIMARY KEY (id, type))",
+		"CREATE TABLE IF NOT EXISTS t_meta (id VARCHAR(128) NOT NULL PRIMARY KEY, meta TEXT NOT NULL)",
+		"CREATE TABLE IF NOT EXISTS t_document (id VARCHAR(128) NOT NULL, type VARCHAR(16) NOT NULL, doc TEXT NOT NULL, PRIMARY KEY (id, type))",
+		"CREATE TABLE IF NOT EXISTS t_msg_key (sender VARCHAR(128) NOT NULL, receiver VARCHAR(128) NOT NULL, data BLOB NOT NULL, PRIMARY KEY (sender, receiver))",
+		"CREATE TABLE IF NOT EXISTS t_ans (alias VARCHAR(32) NOT NULL PRIMARY KEY, id VARCHAR(128) NOT NULL)",
+		"CREATE TABLE IF NOT EXISTS t_login (id VARCHAR(128) NOT NULL PRIMARY KEY, time BIGINT NOT NULL, cmd TEXT NOT NULL, msg TEXT NOT NULL)",
+		"CREATE TABLE IF NOT EXISTS t_user (id VARCHAR(128) NOT NULL PRIMARY KEY, priority BIGINT NOT NULL)",
+		"CREATE TABLE IF NOT EXISTS t_contact (uid VARCHAR(128) NOT NULL, contact VARCHAR(128) NOT NULL, PRIMARY KEY (uid, contact))",
+		"CREATE TABLE IF NOT EXISTS t_member (gid VARCHAR(128) NOT NULL, member VARCHAR(128) NOT NULL, PRIMARY KEY (gid, member))",
+		"CREATE TABLE IF NOT EXISTS t_group (gid VARCHAR(128) NOT NULL PRIMARY KEY, founder VARCHAR(128), owner VARCHAR(128), assistants TEXT)",
+	},
+	// version 2
+	{
+		"CREATE TABLE IF NOT EXISTS t_conversation (id VARCHAR(128) NOT NULL PRIMARY KEY, time BIGINT NOT NULL, unread INT NOT NULL)",
+		"CREATE TABLE IF NOT EXISTS t_message (cid VARCHAR(128) NOT NULL, seq BIGINT NOT NULL, sender VARCHAR(128) NOT NULL, sn VARCHAR(32) NOT NULL, time BIGINT NOT NULL, signature VARCHAR(512), msg TEXT NOT NULL, PRIMARY KEY (cid, seq))",
+		"CREATE INDEX i_message_signature ON t_message (signature)",
+	},
+	// version 3
+	{
+		"CREATE TABLE IF NOT EXISTS t_document_history (id VARCHAR(128) NOT NULL, type VARCHAR(16) NOT NULL, time BIGINT NOT NULL, doc TEXT NOT NULL)",
+		"CREATE INDEX i_document_history ON t_document_history (id, type)",
+	},
+	// version 4
+	{
+		"ALTER TABLE t_contact ADD COLUMN seq BIGINT NOT NULL DEFAULT 0",
+		"ALTER TABLE t_member ADD COLUMN seq BIGINT NOT NULL DEFAULT 0",
+		"CREATE TABLE IF NOT EXISTS t_keystore (info TEXT NOT NULL)",
+	},
+}
+
+/**
+ *  SQL Storage
+ *  ~~~~~~~~~~~
+ *
+ *  Database implementation on 'database/sql',
+ *  the driver must be imported by the application, e.g.:
+ *
+ *      import _ "github.com/mattn/go-sqlite3"
+ */
+type SQLStorage struct {
+	Database
+
+	_db *sql.DB
+
+	_password SymmetricKey
+	_passwordLock sync.RWMutex
+
+	_locks lockSet           // entity locks for read-modify-write: ID -> mutex
+	_usersLock sync.Mutex    // guards priorities of local users
+}
+
+func (db *SQLStorage) Init(driver string, source string) *SQLStorage {
+	if isDriverRegistered(driver) == false {
+		db.error(fmt.Sprintf("SQL driver not registered: %s, import it in the application, e.g.: " +
+			"import _ \"github.com/mattn/go-sqlite3\"", driver))
+		return nil
+	}
+	conn, err := sql.Open(driver, source)
+	if err != nil {
+		db.error(fmt.Sprintf("failed to open database: %s, %v", driver, err))
+		return nil
+	}
+	db._db = conn
+	db._password = GetPlainKey()
+	if db.migrate() == false {
+		_ = conn.Close()
+		return nil
+	}
+	return db
+}
+
+func (db *SQLStorage) Close() bool {
+	return db._db.Close() == nil
+}
+
+func isDriverRegistered(driver string) bool {
+	for _, name := range sql.Drivers() {
+		if name == driver {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ *  Password for private key encryption
+ *  ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+ */
+func (db *SQLStorage) Password() SymmetricKey {
+	db._passwordLock.RLock()
+	defer db._passwordLock.RUnlock()
+	return db._password
+}
+func (db *SQLStorage) setPassword(key SymmetricKey) {
+	db._passwordLock.Lock()
+	db._password = key
+	db._passwordLock.Unlock()
+}
+
+func (db *SQLStorage) IsEncrypted() bool {
+	return db.queryInt("SELECT COUNT(*) FROM t_keystore") > 0
+}
+
+func (db *SQLStorage) SetPassword(password string) bool {
+	info := db.loadKeystore()
+	if info == nil {
+		if password == "" {
+			db.setPassword(GetPlainKey())
+			return true
+		} else if db.countSecrets() > 0 {
+			db.error("Plain secrets exist, reset password to encrypt them")
+			return false
+		}
+		info = createKeystore(password)
+		if db.saveKeystore(info) == false {
+			return false
+		}
+	}
+	key := keystoreKey(info, password)
+	if key == nil {
+		db.error("Wrong password for keystore")
+		return false
+	}
+	db.setPassword(key)
+	if keystoreOutdated(info) {
+		db.warning("Upgrading keystore")
+		db.ResetPassword(password)
+	}
+	return true
+}
+
+func (db *SQLStorage) ResetPassword(password string) bool {
+	// 1. prepare new password
+	var info map[string]interface{}
+	var key SymmetricKey
+	if password == "" {
+		key = GetPlainKey()
+	} else {
+		info = createKeystore(password)
+		key = keystoreKey(info, password)
+	}
+	// 2. re-encrypt all secrets & update keystore in one transaction
+	old := db.Password()
+	ok := db.transact(func(tx *sql.Tx) error {
+		if err := reencryptSecrets(tx, "t_private_key", [2]string{"id", "type"}, old, key); err != nil {
+			return err
+		}
+		if err := reencryptSecrets(tx, "t_msg_key", [2]string{"sender", "receiver"}, old, key); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM t_keystore"); err != nil {
+			return err
+		} else if info == nil {
+			return nil
+		}
+		_, err := tx.Exec("INSERT INTO t_keystore (info) VALUES (?)", JSONEncodeMap(info))
+		return err
+	})
+	if ok {
+		db.setPassword(key)
+	}
+	return ok
+}
+
+// re-encrypt data column of table with two primary key columns
+func reencryptSecrets(tx *sql.Tx, table string, keyColumns [2]string, old SymmetricKey, key SymmetricKey) error {
+	// NOTICE: rows must be closed before updating
+	secrets := make([][3]interface{}, 0)
+	rows, err := tx.Query("SELECT " + keyColumns[0] + ", " + keyColumns[1] + ", data FROM " + table)
+	if err != nil {
+		return err
+	}
+	var k1, k2 string
+	var data []byte
+	for rows.Next() {
+		if err = rows.Scan(&k1, &k2, &data); err != nil {
+			break
+		}
+		plaintext := old.Decrypt(data)
+		if plaintext == nil {
+			err = fmt.Errorf("failed to decrypt secret: %s, %s, %s", table, k1, k2)
+			break
+		}
+		secrets = append(secrets, [3]interface{}{key.Encrypt(plaintext), k1, k2})
+	}
+	_ = rows.Close()
+	if err != nil {
+		return err
+	}
+	query := "UPDATE " + table + " SET data=? WHERE " + keyColumns[0] + "=? AND " + keyColumns[1] + "=?"
+	for _, item := range secrets {
+		if _, err = tx.Exec(query, item[0], item[1], item[2]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *SQLStorage) countSecrets() int64 {
+	return db.queryInt("SELECT COUNT(*) FROM t_private_key") + db.queryInt("SELECT COUNT(*) FROM t_msg_key")
+}
+
+func (db *SQLStorage) loadKeystore() map[string]interface{} {
+	json := db.queryText("SELECT info FROM t_keystore LIMIT 1")
+	if json == "" {
+		return nil
+	}
+	return decodeJSONMap(json)
+}
+
+func (db *SQLStorage) saveKeystore(info map[string]interface{}) bool {
+	return db.transact(func(tx *sql.Tx) error {
+		if _, err := tx.Exec("DELETE FROM t_keystore"); err != nil {
+			return err
+		}
+		_, err := tx.Exec("INSERT INTO t_keystore (info) VALUES (?)", JSONEncodeMap(info))
+		return err
+	})
+}
+
+// data source is specified when opening
+func (db *SQLStorage) SetRoot(root string) {
+	db.warning("root directory not supported: " + root)
+}
+
+// Schema version
+func (db *SQLStorage) Version() int {
+	var version int
+	row := db._db.QueryRow("SELECT version FROM t_schema_version")
+	if row.Scan(&version) != nil {
+		return 0
+	}
+	return version
+}
+
+func (db *SQLStorage) migrate() bool {
+	_, err := db._db.Exec("CREATE TABLE IF NOT EXISTS t_schema_version (version INT NOT NULL)")
+	if err != nil {
+		db.error(fmt.Sprintf("failed to create version table: %v", err))
+		return false
+	}
+	version := db.Version()
+	for version < len(sqlMigrations) {
+		steps := sqlMigrations[version]
+		version++
+		db.log(fmt.Sprintf("Upgrading schema to version %d", version))
+		ok := db.transact(func(tx *sql.Tx) error {
+			for _, stmt := range steps {
+				if _, err := tx.Exec(stmt); err != nil {
+					if isDuplicateError(err) {
+						db.warning(fmt.Sprintf("skip migration: %s, %v", stmt, err))
+						continue
+					}
+					return err
+				}
+			}
+			if _, err := tx.Exec("DELETE FROM t_schema_version"); err != nil {
+				return err
+			}
+			_, err := tx.Exec("INSERT INTO t_schema_version (version) VALUES (?)", version)
+			return err
+		})
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// index or column created by the previous attempt of a migration
+func isDuplicateError(err error) bool {
+	text := strings.ToLower(err.Error())
+	return strings.Contains(text, "already exists") ||  // SQLite: index ... already exists
+		strings.Contains(text, "duplicate column") ||   // SQLite & MySQL: duplicate column name
+		strings.Contains(text, "duplicate key name")    // MySQL: index
+}
+
+//
+//  SQL
+//
+
+// return it in transaction to rollback without error, e.g. duplicated record
+var errUnchanged = errors.New("nothing changed")
+
+// *sql.DB or *sql.Tx
+type sqlQuerier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func (db *SQLStorage) transact(fn func(tx *sql.Tx) error) bool {
+	tx, err := db._db.Begin()
+	if err != nil {
+		db.error(fmt.Sprintf("failed to begin transaction: %v", err))
+		return false
+	}
+	if err = fn(tx); err == errUnchanged {
+		_ = tx.Rollback()
+		return false
+	} else if err != nil {
+		_ = tx.Rollback()
+		db.error(fmt.Sprintf("transaction failed: %v", err))
+		return false
+	}
+	if err = tx.Commit(); err != nil {
+		db.error(fmt.Sprintf("failed to commit: %v", err))
+		return false
+	}
+	return true
+}
+
+// execute and check rows affected
+func (db *SQLStorage) update(query string, args ...interface{}) bool {
+	res, err := db._db.Exec(query, args...)
+	if err != nil {
+		db.error(fmt.Sprintf("%s, %v", query, err))
+		return false
+	}
+	count, err := res.RowsAffected()
+	return err == nil && count > 0
+}
+
+// replace the row with primary keys in 'where'
+func (db *SQLStorage) replace(table string, where string, keys []interface{}, columns string, values ...interface{}) bool {
+	return db.transact(func(tx *sql.Tx) error {
+		return replaceRow(tx, table, where, keys, columns, values...)
+	})
+}
+
+func replaceRow(tx *sql.Tx, table string, where string, keys []interface{}, columns string, values ...interface{}) error {
+	marks := "?"
+	for index := 1; index < len(values); index++ {
+		marks += ", ?"
+	}
+	if _, err := tx.Exec("DELETE FROM " + table + " WHERE " + where, keys...); err != nil {
+		return err
+	}
+	_, err := tx.Exec("INSERT INTO " + table + " (" + columns + ") VALUES (" + marks + ")", values...)
+	return err
+}
+
+// scan one value, no error for empty result
+func scanText(q sqlQuerier, query string, args ...interface{}) (string, error) {
+	var text sql.NullString
+	err := q.QueryRow(query, args...).Scan(&text)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return text.String, err
+}
+func scanInt(q sqlQuerier, query string, args ...interface{}) (int64, error) {
+	var value sql.NullInt64
+	err := q.QueryRow(query, args...).Scan(&value)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return value.Int64, err
+}
+func scanData(q sqlQuerier, query string, args ...interface{}) ([]byte, error) {
+	var data []byte
+	err := q.QueryRow(query, args...).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (db *SQLStorage) queryText(query string, args ...interface{}) string {
+	text, err := scanText(db._db, query, args...)
+	if err != nil {
+		db.error(fmt.Sprintf("%s, %v", query, err))
+	}
+	return text
+}
+
+func (db *SQLStorage) queryTexts(query string, args ...interface{}) []string {
+	results := make([]string, 0)
+	rows, err := db._db.Query(query, args...)
+	if err != nil {
+		db.error(fmt.Sprintf("%s, %v", query, err))
+		return results
+	}
+	defer rows.Close()
+	var text string
+	for rows.Next() {
+		if rows.Scan(&text) == nil {
+			results = append(results, text)
+		}
+	}
+	return results
+}
+
+func (db *SQLStorage) queryData(query string, args ...interface{}) []byte {
+	data, err := scanData(db._db, query, args...)
+	if err != nil {
+		db.error(fmt.Sprintf("%s, %v", query, err))
+	}
+	return data
+}
+
+func (db *SQLStorage) queryInt(query string, args ...interface{}) int64 {
+	value, err := scanInt(db._db, query, args...)
+	if err != nil {
+		db.error(fmt.Sprintf("%s, %v", query, err))
+	}
+	return value
+}
+
+//
+//  Log
+//
+
+func (db *SQLStorage) debug(msg string) {
+	msg = fmt.Sprintf("SQLStorage > %s", msg)
+	LogDebug(msg)
+}
+
+func (db *SQLStorage) log(msg string) {
+	msg = fmt.Sprintf("SQLStorage > %s", msg)
+	LogInfo(msg)
+}
+
+func (db *SQLStorage) warning(msg string) {
+	msg = fmt.Sprintf("SQLStorage > %s", msg)
+	LogWarning(msg)
+}
+
+func (db *SQLStorage) error(msg string) {
+	msg = fmt.Sprintf("SQLStorage > %s", msg)
+	LogError(msg)
+}
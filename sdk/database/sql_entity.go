@@ -0,0 +1,294 @@
+/* license: https://mit-license.org
+ * ==============================================================================
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2021 Albert Moky
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ * ==============================================================================
+ */
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	. "github.com/dimchat/demo-go/sdk/common/db"
+	. "github.com/dimchat/mkm-go/crypto"
+	. "github.com/dimchat/mkm-go/format"
+	. "github.com/dimchat/mkm-go/protocol"
+)
+
+//-------- PrivateKeyTable
+
+func (db *SQLStorage) SavePrivateKey(user ID, key PrivateKey, keyType string, sign bool, decrypt bool) bool {
+	db._locks.Lock(user)
+	defer db._locks.Unlock(user)
+	return db.transact(func(tx *sql.Tx) error {
+		keys, err := db.readPrivateKeys(tx, user, keyType)
+		if err != nil {
+			return err
+		}
+		if keyType == META_KEY {
+			if len(keys) > 0 {
+				// identity key won't change
+				return errUnchanged
+			}
+			return db.writePrivateKeys(tx, user, META_KEY, []PrivateKey{key})
+		}
+		keys = updateKeys(keys, key, sign)
+		if keys == nil {
+			return errUnchanged
+		}
+		return db.writePrivateKeys(tx, user, VISA_KEY, keys)
+	})
+}
+
+func (db *SQLStorage) RemovePrivateKey(user ID, key PrivateKey, keyType string) bool {
+	if keyType == META_KEY {
+		// identity key won't change
+		return false
+	}
+	db._locks.Lock(user)
+	defer db._locks.Unlock(user)
+	return db.transact(func(tx *sql.Tx) error {
+		keys, err := db.readPrivateKeys(tx, user, VISA_KEY)
+		if err != nil {
+			return err
+		}
+		index := findKey(keys, key)
+		if index < 0 {
+			return errUnchanged
+		}
+		return db.writePrivateKeys(tx, user, VISA_KEY, removeKey(keys, index))
+	})
+}
+
+func (db *SQLStorage) GetPrivateKeysForDecryption(user ID) []DecryptKey {
+	msgKeys := db.getCommunicationKeys(user)
+	keys := make([]DecryptKey, 0, len(msgKeys) + 1)
+	for _, item := range msgKeys {
+		decKey, ok := item.(DecryptKey)
+		if ok && decKey != nil {
+			keys = append(keys, decKey)
+		}
+	}
+	idKey := db.getIdentityKey(user)
+	decKey, ok := idKey.(DecryptKey)
+	if ok && decKey != nil && findKey(msgKeys, idKey) < 0 {
+		keys = append(keys, decKey)
+	}
+	return keys
+}
+
+func (db *SQLStorage) GetPrivateKeyForSignature(user ID) PrivateKey {
+	keys := db.getCommunicationKeys(user)
+	if len(keys) > 0 {
+		// sign message with communication key
+		return keys[0]
+	} else {
+		// if communication keys not exists, use identity key to sign message
+		return db.getIdentityKey(user)
+	}
+}
+
+func (db *SQLStorage) GetPrivateKeyForVisaSignature(user ID) PrivateKey {
+	return db.getIdentityKey(user)
+}
+
+func (db *SQLStorage) getIdentityKey(user ID) PrivateKey {
+	keys := db.loadPrivateKeys(user, META_KEY)
+	if len(keys) > 0 {
+		return keys[0]
+	}
+	return nil
+}
+
+func (db *SQLStorage) getCommunicationKeys(user ID) []PrivateKey {
+	return db.loadPrivateKeys(user, VISA_KEY)
+}
+
+func (db *SQLStorage) loadPrivateKeys(user ID, keyType string) []PrivateKey {
+	keys, err := db.readPrivateKeys(db._db, user, keyType)
+	if err != nil {
+		db.error(err.Error())
+	}
+	return keys
+}
+
+// error when failed to decrypt, so the keys won't be overwritten
+func (db *SQLStorage) readPrivateKeys(q sqlQuerier, user ID, keyType string) ([]PrivateKey, error) {
+	keys := make([]PrivateKey, 0, 1)
+	data, err := scanData(q, "SELECT data FROM t_private_key WHERE id=? AND type=?", user.String(), keyType)
+	if err != nil || data == nil {
+		return keys, err
+	}
+	data = db.Password().Decrypt(data)
+	if data == nil {
+		return keys, fmt.Errorf("failed to decrypt private keys: %s", user)
+	}
+	arr := decodeJSONList(UTF8Decode(data))
+	for _, item := range arr {
+		key := PrivateKeyParse(item)
+		if key != nil {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (db *SQLStorage) writePrivateKeys(tx *sql.Tx, user ID, keyType string, keys []PrivateKey) error {
+	arr := make([]interface{}, 0, len(keys))
+	for _, item := range keys {
+		arr = append(arr, item.Map())
+	}
+	data := db.Password().Encrypt(UTF8Encode(JSONEncodeList(arr)))
+	return replaceRow(tx, "t_private_key", "id=? AND type=?", []interface{}{user.String(), keyType},
+		"id, type, data", user.String(), keyType, data)
+}
+
+//-------- MetaTable
+
+func (db *SQLStorage) SaveMeta(meta Meta, entity ID) bool {
+	if MetaMatchID(meta, entity) == false {
+		return false
+	}
+	json := JSONEncodeMap(meta.Map())
+	return db.replace("t_meta", "id=?", []interface{}{entity.String()},
+		"id, meta", entity.String(), json)
+}
+
+func (db *SQLStorage) GetMeta(entity ID) Meta {
+	json := db.queryText("SELECT meta FROM t_meta WHERE id=?", entity.String())
+	if json == "" {
+		return nil
+	}
+	return MetaParse(decodeJSONMap(json))
+}
+
+//-------- DocumentTable
+
+func (db *SQLStorage) SaveDocument(doc Document) bool {
+	if doc.IsValid() == false {
+		return false
+	}
+	identifier := doc.ID()
+	docType := documentType(doc.Type(), identifier)
+	json := JSONEncodeMap(doc.Map())
+	db._locks.Lock(identifier)
+	defer db._locks.Unlock(identifier)
+	return db.transact(func(tx *sql.Tx) error {
+		// check with current document
+		text, err := scanText(tx, "SELECT doc FROM t_document WHERE id=? AND type=?", identifier.String(), docType)
+		if err != nil {
+			return err
+		}
+		var old Document
+		if text != "" {
+			old = DocumentParse(decodeJSONMap(text))
+		}
+		if old != nil && documentIsOlder(doc, old) {
+			db.warning("document expired: " + identifier.String())
+			return errUnchanged
+		}
+		// keep the replaced one in history
+		if old != nil && documentIsSame(doc, old) == false {
+			if err := saveDocumentHistory(tx, old, docType); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Exec("DELETE FROM t_document WHERE id=? AND type=?", identifier.String(), docType); err != nil {
+			return err
+		}
+		_, err = tx.Exec("INSERT INTO t_document (id, type, doc) VALUES (?, ?, ?)", identifier.String(), docType, json)
+		return err
+	})
+}
+
+func (db *SQLStorage) GetDocument(entity ID, docType string) Document {
+	docType = documentType(docType, entity)
+	json := db.queryText("SELECT doc FROM t_document WHERE id=? AND type=?", entity.String(), docType)
+	if json == "" {
+		return nil
+	}
+	return DocumentParse(decodeJSONMap(json))
+}
+
+func (db *SQLStorage) GetDocumentHistory(entity ID, docType string) []Document {
+	docType = documentType(docType, entity)
+	array := db.queryTexts("SELECT doc FROM t_document_history WHERE id=? AND type=? ORDER BY time DESC",
+		entity.String(), docType)
+	history := make([]Document, 0, len(array))
+	for _, json := range array {
+		doc := DocumentParse(decodeJSONMap(json))
+		if doc != nil {
+			history = append(history, doc)
+		}
+	}
+	return history
+}
+
+// insert document into history, and remove the oldest ones
+func saveDocumentHistory(tx *sql.Tx, doc Document, docType string) error {
+	identifier := doc.ID().String()
+	_, err := tx.Exec("INSERT INTO t_document_history (id, type, time, doc) VALUES (?, ?, ?, ?)",
+		identifier, docType, doc.Time().Unix(), JSONEncodeMap(doc.Map()))
+	if err != nil {
+		return err
+	}
+	rows, err := tx.Query("SELECT time FROM t_document_history WHERE id=? AND type=? ORDER BY time DESC",
+		identifier, docType)
+	if err != nil {
+		return err
+	}
+	times := make([]int64, 0, MaxDocumentHistory+1)
+	var value int64
+	for rows.Next() {
+		if rows.Scan(&value) == nil {
+			times = append(times, value)
+		}
+	}
+	_ = rows.Close()
+	if len(times) <= MaxDocumentHistory {
+		return nil
+	}
+	_, err = tx.Exec("DELETE FROM t_document_history WHERE id=? AND type=? AND time<?",
+		identifier, docType, times[MaxDocumentHistory-1])
+	return err
+}
+
+//-------- MsgKeyTable
+
+func (db *SQLStorage) GetKey(from ID, to ID) SymmetricKey {
+	data := db.queryData("SELECT data FROM t_msg_key WHERE sender=? AND receiver=?", from.String(), to.String())
+	if data == nil {
+		return nil
+	}
+	data = db.Password().Decrypt(data)
+	if data == nil {
+		db.error("failed to decrypt message key: " + from.String() + " -> " + to.String())
+		return nil
+	}
+	return SymmetricKeyParse(decodeJSONMap(UTF8Decode(data)))
+}
+
+func (db *SQLStorage) SaveKey(from ID, to ID, key SymmetricKey) bool {
+	data := db.Password().Encrypt(UTF8Encode(JSONEncodeMap(key.Map())))
+	return db.replace("t_msg_key", "sender=? AND receiver=?", []interface{}{from.String(), to.String()},
+		"sender, receiver, data", from.String(), to.String(), data)
+}
@@ -0,0 +1,251 @@
+/* license: https://mit-license.org
+ * ==============================================================================
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2021 Albert Moky
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ * ==============================================================================
+ */
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	. "github.com/dimchat/demo-go/sdk/utils"
+	. "github.com/dimchat/dkd-go/protocol"
+	. "github.com/dimchat/mkm-go/format"
+	. "github.com/dimchat/mkm-go/protocol"
+)
+
+//-------- ConversationTable
+
+func (db *SQLStorage) NumberOfConversations() int {
+	return int(db.queryInt("SELECT COUNT(*) FROM t_conversation"))
+}
+
+func (db *SQLStorage) ConversationAtIndex(index int) ID {
+	return IDParse(db.queryText("SELECT id FROM t_conversation ORDER BY time DESC LIMIT 1 OFFSET ?", index))
+}
+
+func (db *SQLStorage) RemoveConversationAtIndex(index int) bool {
+	entity := db.ConversationAtIndex(index)
+	if entity == nil {
+		return false
+	}
+	return db.RemoveConversation(entity)
+}
+
+func (db *SQLStorage) RemoveConversation(entity ID) bool {
+	db._locks.Lock(entity)
+	defer db._locks.Unlock(entity)
+	return db.transact(func(tx *sql.Tx) error {
+		res, err := tx.Exec("DELETE FROM t_conversation WHERE id=?", entity.String())
+		if err != nil {
+			return err
+		} else if count, _ := res.RowsAffected(); count == 0 {
+			// not found
+			return errUnchanged
+		}
+		_, err = tx.Exec("DELETE FROM t_message WHERE cid=?", entity.String())
+		return err
+	})
+}
+
+//-------- MessageTable
+
+func (db *SQLStorage) NumberOfMessages(entity ID) int {
+	return int(db.queryInt("SELECT COUNT(*) FROM t_message WHERE cid=?", entity.String()))
+}
+
+func (db *SQLStorage) NumberOfUnreadMessages(entity ID) int {
+	return int(db.queryInt("SELECT unread FROM t_conversation WHERE id=?", entity.String()))
+}
+
+func (db *SQLStorage) ClearUnreadMessages(entity ID) bool {
+	return db.update("UPDATE t_conversation SET unread=0 WHERE id=? AND unread>0", entity.String())
+}
+
+func (db *SQLStorage) LastMessage(entity ID) InstantMessage {
+	return db.MessageAtIndex(0, entity)
+}
+
+func (db *SQLStorage) LastReceivedMessage(user ID) InstantMessage {
+	json := db.queryText("SELECT msg FROM t_message WHERE sender<>? ORDER BY time DESC LIMIT 1", user.String())
+	return parseMessage(json)
+}
+
+func (db *SQLStorage) MessageAtIndex(index int, entity ID) InstantMessage {
+	json := db.queryText("SELECT msg FROM t_message WHERE cid=? ORDER BY seq DESC LIMIT 1 OFFSET ?", entity.String(), index)
+	return parseMessage(json)
+}
+
+func (db *SQLStorage) InsertMessage(iMsg InstantMessage, entity ID) bool {
+	cid := entity.String()
+	where, args := messageMatcher(iMsg, entity)
+	unread := 0
+	if containsID(db.AllUsers(), iMsg.Sender()) == false {
+		// received from others
+		unread = 1
+	}
+	signature, _ := iMsg.Get("signature").(string)
+	sn := fmt.Sprintf("%v", iMsg.Content().SN())
+	msgTime := iMsg.Time().Unix()
+	json := JSONEncodeMap(iMsg.Map())
+	db._locks.Lock(entity)
+	defer db._locks.Unlock(entity)
+	return db.transact(func(tx *sql.Tx) error {
+		if count, err := scanInt(tx, "SELECT COUNT(*) FROM t_message WHERE " + where, args...); err != nil {
+			return err
+		} else if count > 0 {
+			// duplicated
+			return errUnchanged
+		}
+		var seq sql.NullInt64
+		if err := tx.QueryRow("SELECT MAX(seq) FROM t_message WHERE cid=?", cid).Scan(&seq); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("INSERT INTO t_message (cid, seq, sender, sn, time, signature, msg) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			cid, seq.Int64 + 1, iMsg.Sender().String(), sn, msgTime, signature, json); err != nil {
+			return err
+		}
+		// update conversation
+		res, err := tx.Exec("UPDATE t_conversation SET unread=unread+?, time=? WHERE id=? AND time<=?", unread, msgTime, cid, msgTime)
+		if err != nil {
+			return err
+		}
+		if count, _ := res.RowsAffected(); count > 0 {
+			return nil
+		}
+		res, err = tx.Exec("UPDATE t_conversation SET unread=unread+? WHERE id=?", unread, cid)
+		if err != nil {
+			return err
+		}
+		if count, _ := res.RowsAffected(); count > 0 {
+			return nil
+		}
+		_, err = tx.Exec("INSERT INTO t_conversation (id, time, unread) VALUES (?, ?, ?)", cid, msgTime, unread)
+		return err
+	})
+}
+
+func (db *SQLStorage) RemoveMessage(iMsg InstantMessage, entity ID) bool {
+	cid := entity.String()
+	where, args := messageMatcher(iMsg, entity)
+	db._locks.Lock(entity)
+	defer db._locks.Unlock(entity)
+	return db.transact(func(tx *sql.Tx) error {
+		if res, err := tx.Exec("DELETE FROM t_message WHERE " + where, args...); err != nil {
+			return err
+		} else if count, _ := res.RowsAffected(); count == 0 {
+			// not found
+			return errUnchanged
+		}
+		// update conversation
+		var count int64
+		var msgTime sql.NullInt64
+		if err := tx.QueryRow("SELECT COUNT(*), MAX(time) FROM t_message WHERE cid=?", cid).Scan(&count, &msgTime); err != nil {
+			return err
+		}
+		if count == 0 {
+			// no message left, remove the conversation from index
+			_, err := tx.Exec("DELETE FROM t_conversation WHERE id=?", cid)
+			return err
+		}
+		_, err := tx.Exec("UPDATE t_conversation SET time=?, unread=CASE WHEN unread>? THEN ? ELSE unread END WHERE id=?",
+			msgTime.Int64, count, count, cid)
+		return err
+	})
+}
+
+func (db *SQLStorage) WithdrawMessage(iMsg InstantMessage, entity ID) bool {
+	where, args := messageMatcher(iMsg, entity)
+	return db.updateMessage(entity, where, args, func(target InstantMessage) {
+		// only mark it here, the sender should notify the receiver by itself
+		target.Set("state", MessageStateWithdrawn)
+	})
+}
+
+func (db *SQLStorage) SaveReceipt(iMsg InstantMessage, entity ID) bool {
+	where, args := receiptMatcher(iMsg.Content(), entity)
+	if where == "" {
+		// cannot match message without signature or original envelope
+		return false
+	}
+	return db.updateMessage(entity, where, args, func(target InstantMessage) {
+		applyReceipt(target, iMsg.Sender())
+	})
+}
+
+// load the message matched, modify and save it back
+func (db *SQLStorage) updateMessage(entity ID, where string, args []interface{}, modify func(target InstantMessage)) bool {
+	db._locks.Lock(entity)
+	defer db._locks.Unlock(entity)
+	return db.transact(func(tx *sql.Tx) error {
+		json, err := scanText(tx, "SELECT msg FROM t_message WHERE " + where, args...)
+		if err != nil {
+			return err
+		}
+		target := parseMessage(json)
+		if target == nil {
+			// target message not found
+			return errUnchanged
+		}
+		modify(target)
+		values := append([]interface{}{JSONEncodeMap(target.Map())}, args...)
+		_, err = tx.Exec("UPDATE t_message SET msg=? WHERE " + where, values...)
+		return err
+	})
+}
+
+// match by signature, or by sender & serial number
+func messageMatcher(iMsg InstantMessage, entity ID) (string, []interface{}) {
+	signature, _ := iMsg.Get("signature").(string)
+	if signature != "" {
+		return "cid=? AND signature=?", []interface{}{entity.String(), signature}
+	}
+	sn := fmt.Sprintf("%v", iMsg.Content().SN())
+	return "cid=? AND sender=? AND sn=?", []interface{}{entity.String(), iMsg.Sender().String(), sn}
+}
+
+// match by signature, or by the original sender & serial number in receipt
+func receiptMatcher(receipt Content, entity ID) (string, []interface{}) {
+	cid := entity.String()
+	signature, _ := receipt.Get("signature").(string)
+	sender := IDParse(receipt.Get("sender"))
+	sn, ok := ToInt64(receipt.Get("sn"))
+	if sender == nil || !ok {
+		if signature == "" {
+			return "", nil
+		}
+		return "cid=? AND signature=?", []interface{}{cid, signature}
+	}
+	number := fmt.Sprintf("%v", uint64(sn))
+	if signature == "" {
+		return "cid=? AND sender=? AND sn=?", []interface{}{cid, sender.String(), number}
+	}
+	return "cid=? AND (signature=? OR (sender=? AND sn=?))", []interface{}{cid, signature, sender.String(), number}
+}
+
+func parseMessage(json string) InstantMessage {
+	if json == "" {
+		return nil
+	}
+	return InstantMessageParse(decodeJSONMap(json))
+}
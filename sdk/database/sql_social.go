@@ -0,0 +1,333 @@
+/* license: https://mit-license.org
+ * ==============================================================================
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2021 Albert Moky
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ * ==============================================================================
+ */
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	. "github.com/dimchat/dkd-go/protocol"
+	. "github.com/dimchat/mkm-go/format"
+	. "github.com/dimchat/mkm-go/protocol"
+	. "github.com/dimchat/mkm-go/types"
+	. "github.com/dimchat/sdk-go/dimp/protocol"
+)
+
+//-------- AddressNameTable
+
+func (db *SQLStorage) GetIdentifier(alias string) ID {
+	identifier := reserveANS(make(map[string]ID))[alias]
+	if identifier == nil {
+		identifier = IDParse(db.queryText("SELECT id FROM t_ans WHERE alias=?", alias))
+	}
+	return identifier
+}
+
+func (db *SQLStorage) AddRecord(identifier ID, alias string) bool {
+	if len(alias) == 0 || ValueIsNil(identifier) {
+		return false
+	}
+	return db.replace("t_ans", "alias=?", []interface{}{alias},
+		"alias, id", alias, identifier.String())
+}
+
+func (db *SQLStorage) RemoveRecord(alias string) bool {
+	if len(alias) == 0 {
+		return false
+	}
+	return db.update("DELETE FROM t_ans WHERE alias=?", alias)
+}
+
+func (db *SQLStorage) AllRecords() map[string]ID {
+	records := make(map[string]ID)
+	rows, err := db._db.Query("SELECT alias, id FROM t_ans")
+	if err != nil {
+		db.error(fmt.Sprintf("failed to query ANS records: %v", err))
+		return reserveANS(records)
+	}
+	defer rows.Close()
+	var alias, identifier string
+	for rows.Next() {
+		if rows.Scan(&alias, &identifier) == nil {
+			records[alias] = IDParse(identifier)
+		}
+	}
+	return reserveANS(records)
+}
+
+//-------- LoginTable
+
+func (db *SQLStorage) GetLoginCommand(user ID) LoginCommand {
+	json := db.queryText("SELECT cmd FROM t_login WHERE id=?", user.String())
+	if json == "" {
+		return nil
+	}
+	cmd, _ := ContentParse(decodeJSONMap(json)).(LoginCommand)
+	return cmd
+}
+
+func (db *SQLStorage) GetLoginMessage(user ID) ReliableMessage {
+	json := db.queryText("SELECT msg FROM t_login WHERE id=?", user.String())
+	if json == "" {
+		return nil
+	}
+	return ReliableMessageParse(decodeJSONMap(json))
+}
+
+func (db *SQLStorage) SaveLoginCommandMessage(cmd LoginCommand, msg ReliableMessage) bool {
+	// 1. verify sender ID
+	identifier := cmd.ID()
+	if msg.Sender().Equal(identifier) == false {
+		return false
+	}
+	db._locks.Lock(identifier)
+	defer db._locks.Unlock(identifier)
+	return db.transact(func(tx *sql.Tx) error {
+		// 2. check last login time
+		newTime := cmd.Time().Unix()
+		oldTime, err := scanInt(tx, "SELECT time FROM t_login WHERE id=?", identifier.String())
+		if err != nil {
+			return err
+		} else if newTime <= oldTime {
+			// expired command, drop it
+			return errUnchanged
+		}
+		// 3. save them
+		return replaceRow(tx, "t_login", "id=?", []interface{}{identifier.String()},
+			"id, time, cmd, msg", identifier.String(), newTime,
+			JSONEncodeMap(cmd.Map()), JSONEncodeMap(msg.Map()))
+	})
+}
+
+//-------- UserTable
+
+func (db *SQLStorage) AllUsers() []ID {
+	return parseIDList(db.queryTexts("SELECT id FROM t_user ORDER BY priority DESC"))
+}
+
+func (db *SQLStorage) AddUser(user ID) bool {
+	db._usersLock.Lock()
+	defer db._usersLock.Unlock()
+	return db.transact(func(tx *sql.Tx) error {
+		if uid, err := scanText(tx, "SELECT id FROM t_user WHERE id=?", user.String()); err != nil {
+			return err
+		} else if uid != "" {
+			// duplicated
+			return errUnchanged
+		}
+		return setUserPriority(tx, user)
+	})
+}
+
+func (db *SQLStorage) RemoveUser(user ID) bool {
+	return db.update("DELETE FROM t_user WHERE id=?", user.String())
+}
+
+func (db *SQLStorage) SetCurrentUser(user ID) {
+	db.SetUserPriority(user)
+}
+
+func (db *SQLStorage) GetCurrentUser() ID {
+	return IDParse(db.queryText("SELECT id FROM t_user ORDER BY priority DESC LIMIT 1"))
+}
+
+// move the user to the front
+func (db *SQLStorage) SetUserPriority(user ID) bool {
+	db._usersLock.Lock()
+	defer db._usersLock.Unlock()
+	return db.transact(func(tx *sql.Tx) error {
+		return setUserPriority(tx, user)
+	})
+}
+
+func setUserPriority(tx *sql.Tx, user ID) error {
+	priority, err := scanInt(tx, "SELECT MAX(priority) FROM t_user")
+	if err != nil {
+		return err
+	}
+	return replaceRow(tx, "t_user", "id=?", []interface{}{user.String()},
+		"id, priority", user.String(), priority + 1)
+}
+
+//-------- ContactTable
+
+func (db *SQLStorage) GetContacts(user ID) []ID {
+	return parseIDList(db.queryTexts("SELECT contact FROM t_contact WHERE uid=? ORDER BY seq", user.String()))
+}
+
+func (db *SQLStorage) AddContact(contact ID, user ID) bool {
+	db._locks.Lock(user)
+	defer db._locks.Unlock(user)
+	return db.appendItem("t_contact", "uid", "contact", user, contact)
+}
+
+func (db *SQLStorage) RemoveContact(contact ID, user ID) bool {
+	return db.update("DELETE FROM t_contact WHERE uid=? AND contact=?", user.String(), contact.String())
+}
+
+func (db *SQLStorage) SaveContacts(contacts []ID, user ID) bool {
+	return db.saveList("t_contact", "uid", "contact", user, contacts)
+}
+
+//-------- GroupTable
+
+func (db *SQLStorage) GetFounder(group ID) ID {
+	return IDParse(db.queryText("SELECT founder FROM t_group WHERE gid=?", group.String()))
+}
+
+func (db *SQLStorage) GetOwner(group ID) ID {
+	return IDParse(db.queryText("SELECT owner FROM t_group WHERE gid=?", group.String()))
+}
+
+func (db *SQLStorage) GetMembers(group ID) []ID {
+	return parseIDList(db.queryTexts("SELECT member FROM t_member WHERE gid=? ORDER BY seq", group.String()))
+}
+
+func (db *SQLStorage) GetAssistants(group ID) []ID {
+	json := db.queryText("SELECT assistants FROM t_group WHERE gid=?", group.String())
+	if json == "" {
+		return nil
+	}
+	return parseIDList(decodeJSONList(json))
+}
+
+func (db *SQLStorage) AddMember(member ID, group ID) bool {
+	db._locks.Lock(group)
+	defer db._locks.Unlock(group)
+	return db.appendItem("t_member", "gid", "member", group, member)
+}
+
+func (db *SQLStorage) RemoveMember(member ID, group ID) bool {
+	return db.update("DELETE FROM t_member WHERE gid=? AND member=?", group.String(), member.String())
+}
+
+func (db *SQLStorage) SaveMembers(members []ID, group ID) bool {
+	return db.saveList("t_member", "gid", "member", group, members)
+}
+
+func (db *SQLStorage) SaveFounder(founder ID, group ID) bool {
+	return db.saveGroupColumn(group, "founder", founder.String())
+}
+
+func (db *SQLStorage) SaveOwner(owner ID, group ID) bool {
+	return db.saveGroupColumn(group, "owner", owner.String())
+}
+
+func (db *SQLStorage) SaveAssistants(bots []ID, group ID) bool {
+	arr := make([]interface{}, 0, len(bots))
+	for _, item := range IDRevert(bots) {
+		arr = append(arr, item)
+	}
+	return db.saveGroupColumn(group, "assistants", JSONEncodeList(arr))
+}
+
+func (db *SQLStorage) RemoveGroup(group ID) bool {
+	return db.transact(func(tx *sql.Tx) error {
+		if _, err := tx.Exec("DELETE FROM t_member WHERE gid=?", group.String()); err != nil {
+			return err
+		}
+		_, err := tx.Exec("DELETE FROM t_group WHERE gid=?", group.String())
+		return err
+	})
+}
+
+func (db *SQLStorage) saveGroupColumn(group ID, column string, value string) bool {
+	gid := group.String()
+	db._locks.Lock(group)
+	defer db._locks.Unlock(group)
+	return db.transact(func(tx *sql.Tx) error {
+		var count int
+		if err := tx.QueryRow("SELECT COUNT(*) FROM t_group WHERE gid=?", gid).Scan(&count); err != nil {
+			return err
+		}
+		if count == 0 {
+			if _, err := tx.Exec("INSERT INTO t_group (gid) VALUES (?)", gid); err != nil {
+				return err
+			}
+		}
+		_, err := tx.Exec("UPDATE t_group SET " + column + "=? WHERE gid=?", value, gid)
+		return err
+	})
+}
+
+// append item to the end of the owner's list, false when duplicated
+func (db *SQLStorage) appendItem(table string, ownerColumn string, itemColumn string, owner ID, item ID) bool {
+	return db.transact(func(tx *sql.Tx) error {
+		where := " FROM " + table + " WHERE " + ownerColumn + "=?"
+		count, err := scanInt(tx, "SELECT COUNT(*)" + where + " AND " + itemColumn + "=?", owner.String(), item.String())
+		if err != nil {
+			return err
+		} else if count > 0 {
+			// duplicated
+			return errUnchanged
+		}
+		seq, err := scanInt(tx, "SELECT MAX(seq)" + where, owner.String())
+		if err != nil {
+			return err
+		}
+		query := "INSERT INTO " + table + " (" + ownerColumn + ", " + itemColumn + ", seq) VALUES (?, ?, ?)"
+		_, err = tx.Exec(query, owner.String(), item.String(), seq + 1)
+		return err
+	})
+}
+
+// replace all items of the owner
+func (db *SQLStorage) saveList(table string, ownerColumn string, itemColumn string, owner ID, items []ID) bool {
+	db._locks.Lock(owner)
+	defer db._locks.Unlock(owner)
+	return db.transact(func(tx *sql.Tx) error {
+		if _, err := tx.Exec("DELETE FROM " + table + " WHERE " + ownerColumn + "=?", owner.String()); err != nil {
+			return err
+		}
+		query := "INSERT INTO " + table + " (" + ownerColumn + ", " + itemColumn + ", seq) VALUES (?, ?, ?)"
+		for index, item := range items {
+			if _, err := tx.Exec(query, owner.String(), item.String(), index + 1); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func parseIDList(array interface{}) []ID {
+	results := make([]ID, 0)
+	switch list := array.(type) {
+	case []string:
+		for _, item := range list {
+			id := IDParse(item)
+			if id != nil {
+				results = append(results, id)
+			}
+		}
+	case []interface{}:
+		for _, item := range list {
+			id := IDParse(item)
+			if id != nil {
+				results = append(results, id)
+			}
+		}
+	}
+	return results
+}
@@ -0,0 +1,127 @@
+//go:build sqlite
+// +build sqlite
+
+/* license: https://mit-license.org
+ * ==============================================================================
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2021 Albert Moky
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ * ==============================================================================
+ */
+package db
+
+import (
+	"fmt"
+	. "github.com/dimchat/mkm-go/protocol"
+	_ "github.com/mattn/go-sqlite3"
+	"path/filepath"
+	"testing"
+)
+
+/**
+ *  SQL storage tests on SQLite, the driver is not a dependency of the module:
+ *
+ *      go get github.com/mattn/go-sqlite3
+ *      go test -race -tags sqlite ./sdk/database/
+ */
+
+func openTestSQLStorage(t *testing.T, path string) *SQLStorage {
+	source := fmt.Sprintf("file:%s?_busy_timeout=5000&_txlock=immediate", path)
+	db := new(SQLStorage).Init("sqlite3", source)
+	if db == nil {
+		t.Fatalf("failed to open database: %s", source)
+	}
+	return db
+}
+
+func TestSQLStorageConcurrentAccess(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	storage := openTestSQLStorage(t, path)
+	hammerDatabase(t, storage)
+	users := storage.AllUsers()
+	storage.Close()
+
+	// reopen
+	reloaded := openTestSQLStorage(t, path)
+	defer reloaded.Close()
+	if reloaded.Version() != len(sqlMigrations) {
+		t.Fatalf("schema version error: %d", reloaded.Version())
+	}
+	if len(reloaded.AllUsers()) != len(users) {
+		t.Fatalf("local users not saved: %v", reloaded.AllUsers())
+	}
+	for _, user := range users {
+		if reloaded.GetPrivateKeyForVisaSignature(user) == nil {
+			t.Errorf("identity key not saved: %s", user)
+		}
+		if reloaded.GetDocument(user, VISA) == nil {
+			t.Errorf("visa not saved: %s", user)
+		}
+	}
+}
+
+func TestSQLStorageMigrateAgain(t *testing.T) {
+	storage := openTestSQLStorage(t, filepath.Join(t.TempDir(), "test.db"))
+	defer storage.Close()
+	users := prepareUsers(t, storage, 1)
+	// interrupted after the statements were executed, before the version saved
+	if _, err := storage._db.Exec("DELETE FROM t_schema_version"); err != nil {
+		t.Fatalf("failed to reset schema version: %v", err)
+	}
+	if storage.migrate() == false {
+		t.Fatal("failed to run migrations again")
+	}
+	if storage.Version() != len(sqlMigrations) {
+		t.Fatalf("schema version error: %d", storage.Version())
+	}
+	if storage.GetMeta(users[0].ID) == nil {
+		t.Errorf("meta lost: %s", users[0].ID)
+	}
+}
+
+func TestSQLStorageMessages(t *testing.T) {
+	storage := openTestSQLStorage(t, filepath.Join(t.TempDir(), "test.db"))
+	defer storage.Close()
+	users := prepareUsers(t, storage, 2)
+	sender, receiver := users[0].ID, users[1].ID
+	iMsg := newTestMessage(sender, receiver, "Hello")
+	if storage.InsertMessage(iMsg, sender) == false {
+		t.Fatal("failed to insert message")
+	}
+	if storage.InsertMessage(iMsg, sender) {
+		t.Error("duplicated message inserted")
+	}
+	if storage.NumberOfMessages(sender) != 1 || storage.NumberOfConversations() != 1 {
+		t.Fatalf("message count error: %d", storage.NumberOfMessages(sender))
+	}
+	if storage.WithdrawMessage(iMsg, sender) == false {
+		t.Error("failed to withdraw message")
+	}
+	if storage.RemoveMessage(iMsg, sender) == false {
+		t.Fatal("failed to remove message")
+	}
+	if storage.RemoveMessage(iMsg, sender) {
+		t.Error("message removed twice")
+	}
+	if storage.NumberOfMessages(sender) != 0 || storage.NumberOfConversations() != 0 {
+		t.Errorf("message not removed: %d", storage.NumberOfMessages(sender))
+	}
+}
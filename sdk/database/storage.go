@@ -26,15 +26,20 @@
 package db
 
 import (
+	"encoding/json"
 	"fmt"
+	. "github.com/dimchat/demo-go/sdk/client/db"
 	. "github.com/dimchat/demo-go/sdk/common/db"
-	. "github.com/dimchat/demo-go/sdk/extensions"
 	. "github.com/dimchat/demo-go/sdk/utils"
 	. "github.com/dimchat/dkd-go/protocol"
 	. "github.com/dimchat/mkm-go/crypto"
 	. "github.com/dimchat/mkm-go/protocol"
-	. "github.com/dimchat/sdk-go/dimp/protocol"
 	. "github.com/dimchat/sdk-go/plugins/crypto"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 type Database interface {
@@ -42,6 +47,7 @@ type Database interface {
 	PrivateKeyTable
 	MetaTable
 	DocumentTable
+	MsgKeyTable
 
 	AddressNameTable
 	LoginTable
@@ -65,76 +71,181 @@ type Storage struct {
 
 	_password SymmetricKey
 
+	_negativeTTL time.Duration  // life span for 'not found' records in caches
+
+	//
+	//  locks
+	//
+
+	_locks lockSet              // entity locks: ID -> mutex
+	_cacheLock sync.RWMutex     // guards map caches below, never held for I/O
+	_ansLock sync.RWMutex       // guards ANS records & file
+	_usersLock sync.Mutex       // guards local users & file
+	_chatsLock sync.Mutex       // guards conversations & file
+	_providersLock sync.Mutex   // guards providers, stations & file
+
 	//
 	//  memory caches
 	//
 
-	_identityKeys map[ID]PrivateKey         // meta keys: ID -> SK
+	_identityKeys *LRUCache                 // meta keys: ID -> SK
 	_communicationKeys map[ID][]PrivateKey  // visa keys: ID -> []SK
 	_decryptionKeys map[ID][]DecryptKey     // visa keys: ID -> []SK
 
-	_metas map[ID]Meta                // meta: ID -> meta
+	_msgKeys map[ID]map[ID]SymmetricKey     // msg keys: sender -> receiver -> PW
+
+	_metas *LRUCache                  // meta: ID -> meta
 
-	_docs map[string]map[ID]Document  // document: type -> ID -> doc
+	_docs *LRUCache                   // document: (ID, type) -> doc
 
 	_ans map[string]ID                // ANS: string -> ID
 
-	_loginCommands map[ID]LoginCommand     // ID -> Login Command
-	_loginMessages map[ID]ReliableMessage  // ID -> Login Message
+	_logins *LRUCache                 // login info: ID -> (cmd, msg)
 
 	_users []ID
 	_contacts map[ID][]ID             // user contacts: ID -> []ID
 
 	_members map[ID][]ID              // group members: ID -> []ID
+	_groups map[ID]*groupRecord       // group info: ID -> founder, owner, assistants
+
+	_conversations []*conversation         // chat boxes, latest first
+	_messages map[ID][]InstantMessage      // chat history: ID -> []msg
+
+	_providers []*ProviderInfo             // service providers
+	_stations map[ID][]*StationInfo        // stations: SP -> []station
+	_providersConfig string                // config file to import providers at first start
 }
 
 func (db *Storage) Init() *Storage {
 
-	db._root = "/tmp/.dim"
+	db._root = DefaultRoot
 
 	db._password = GetPlainKey()
 
 	// private keys
-	db._identityKeys = make(map[ID]PrivateKey)
 	db._communicationKeys = make(map[ID][]PrivateKey)
 	db._decryptionKeys = make(map[ID][]DecryptKey)
 
-	// meta
-	db._metas = make(map[ID]Meta)
+	// message keys
+	db._msgKeys = make(map[ID]map[ID]SymmetricKey)
+
+	// meta keys, metas, documents & login info
+	db._identityKeys = NewLRUCache(DefaultCacheSize)
+	db._metas = NewLRUCache(DefaultCacheSize)
+	db._docs = NewLRUCache(DefaultCacheSize)
+	db._logins = NewLRUCache(DefaultCacheSize)
+	db._negativeTTL = DefaultNegativeTTL
 
-	// documents
-	docs := make(map[string]map[ID]Document)
-	docs[VISA] = make(map[ID]Document)
-	docs[PROFILE] = make(map[ID]Document)
-	docs[BULLETIN] = make(map[ID]Document)
-	db._docs = docs
+	// finish interrupted password resetting
+	recoverKeystore(db)
 
 	// ANS
 	db._ans = loadANS(db)  // make(map[string]ID)
 
-	// login info
-	db._loginCommands = make(map[ID]LoginCommand)
-	db._loginMessages = make(map[ID]ReliableMessage)
-
 	// local users
-	db._users = make([]ID, 0, 1)
+	db._users = nil  // lazy load
 	db._contacts = make(map[ID][]ID)
 
 	// group info
 	db._members = make(map[ID][]ID)
+	db._groups = make(map[ID]*groupRecord)
+
+	// chat history
+	db._conversations = nil  // lazy load
+	db._messages = make(map[ID][]InstantMessage)
+
+	// service providers
+	db._providers = nil  // lazy load
+	db._stations = make(map[ID][]*StationInfo)
 
 	return db
 }
 
+const (
+	DefaultCacheSize = 10000
+	DefaultNegativeTTL = 5 * time.Minute
+)
+
+/**
+ *  Set limits for memory caches (meta keys, metas, documents & login info)
+ *
+ * @param size        - max records for each cache
+ * @param negativeTTL - life span for 'not found' records,
+ *                      so the files saved by other processes can be seen later
+ */
+func (db *Storage) SetCacheLimits(size int, negativeTTL time.Duration) {
+	db._identityKeys.SetCapacity(size)
+	db._metas.SetCapacity(size)
+	db._docs.SetCapacity(size)
+	db._logins.SetCapacity(size)
+	db._cacheLock.Lock()
+	db._negativeTTL = negativeTTL
+	db._cacheLock.Unlock()
+}
+
+// cache a record, the place holder for 'not found' will be expired
+func (db *Storage) cachePut(cache *LRUCache, key interface{}, value interface{}, empty bool) {
+	if empty {
+		db._cacheLock.RLock()
+		ttl := db._negativeTTL
+		db._cacheLock.RUnlock()
+		cache.Put(key, value, ttl)
+	} else {
+		cache.Put(key, value, 0)
+	}
+}
+
 /**
- *  Password for private key encryption
- *  ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+ *  Drop all cached records of the entity,
+ *  so they will be reloaded from local storage next time
+ *
+ * @param identifier - entity ID
  */
-func (db *Storage) Password() SymmetricKey {
-	return db._password
+func (db *Storage) Invalidate(identifier ID) {
+	db._locks.Lock(identifier)
+	defer db._locks.Unlock(identifier)
+	db._identityKeys.Remove(identifier)
+	db._metas.Remove(identifier)
+	db._docs.RemoveMatched(func(key interface{}) bool {
+		return key.(documentKey).identifier == identifier.String()
+	})
+	db._logins.Remove(identifier)
+	db._cacheLock.Lock()
+	delete(db._communicationKeys, identifier)
+	delete(db._decryptionKeys, identifier)
+	delete(db._msgKeys, identifier)
+	delete(db._contacts, identifier)
+	delete(db._members, identifier)
+	delete(db._groups, identifier)
+	delete(db._messages, identifier)
+	db._cacheLock.Unlock()
 }
-func (db *Storage) SetPassword(password string) {
-	db._password = GeneratePassword(password)
+
+// drop all memory caches, e.g. when root directory changed
+func (db *Storage) clearCaches() {
+	db._identityKeys.Clear()
+	db._metas.Clear()
+	db._docs.Clear()
+	db._logins.Clear()
+	db._cacheLock.Lock()
+	db._communicationKeys = make(map[ID][]PrivateKey)
+	db._decryptionKeys = make(map[ID][]DecryptKey)
+	db._msgKeys = make(map[ID]map[ID]SymmetricKey)
+	db._contacts = make(map[ID][]ID)
+	db._members = make(map[ID][]ID)
+	db._groups = make(map[ID]*groupRecord)
+	db._messages = make(map[ID][]InstantMessage)
+	db._cacheLock.Unlock()
+	db._usersLock.Lock()
+	db._users = nil
+	db._usersLock.Unlock()
+	db._chatsLock.Lock()
+	db._conversations = nil
+	db._chatsLock.Unlock()
+	db._providersLock.Lock()
+	db._providers = nil
+	db._stations = make(map[ID][]*StationInfo)
+	db._providersLock.Unlock()
 }
 
 /**
@@ -143,15 +254,33 @@ func (db *Storage) SetPassword(password string) {
  *
  *  File directory for database
  */
+const DefaultRoot = "/tmp/.dim"
+
 func (db *Storage) Root() string {
 	return db._root
 }
+
+/**
+ *  Change root directory, it will be created when not exists;
+ *  should be called before any other operation
+ *
+ *  NOTICE: the keystore of new root should be unlocked again
+ */
 func (db *Storage) SetRoot(root string) {
-	if PathIsExist(root) {
-		db._root = root
-	} else {
-		panic(root)
+	if root == db._root {
+		return
+	} else if err := os.MkdirAll(root, os.ModePerm); err != nil {
+		db.error("Failed to create root directory: " + err.Error())
+		return
 	}
+	db._root = root
+	db.setPassword(GetPlainKey())
+	db.clearCaches()
+	recoverKeystore(db)
+	// reload ANS records from new root
+	db._ansLock.Lock()
+	db._ans = loadANS(db)
+	db._ansLock.Unlock()
 }
 
 // Directory for MKM entity: '.dim/mkm/{zzz}/{ADDRESS}'
@@ -184,20 +313,129 @@ func (db *Storage) prepareDir(filepath string) bool {
 func (db *Storage) readText(path string) string {
 	return ReadTextFile(path)
 }
+
+// read text file line by line, empty lines are skipped;
+// if some lines cannot be parsed, the original file will be moved aside
+// and the valid lines will be saved back
+func (db *Storage) readLines(path string, parse func(line string) bool) {
+	lines := strings.Split(db.readText(path), "\n")
+	valid := make([]string, 0, len(lines))
+	invalid := 0
+	for _, rec := range lines {
+		if len(rec) == 0 {
+			// skip empty line
+			continue
+		} else if parse(rec) {
+			valid = append(valid, rec)
+		} else {
+			db.error("Invalid record: " + rec + ", file: " + path)
+			invalid++
+		}
+	}
+	if invalid == 0 {
+		return
+	}
+	db.quarantine(path)
+	if len(valid) > 0 {
+		db.writeText(path, strings.Join(valid, "\n") + "\n")
+	}
+}
 func (db *Storage) readMap(path string) map[string]interface{} {
-	info := ReadJSONFile(path)
-	if info == nil {
+	if PathIsExist(path) == false {
+		return nil
+	}
+	info, ok := ReadJSONFile(path).(map[string]interface{})
+	if !ok {
+		db.quarantine(path)
 		return nil
-	} else {
-		return info.(map[string]interface{})
 	}
+	return info
+}
+func (db *Storage) readList(path string) []interface{} {
+	if PathIsExist(path) == false {
+		return nil
+	}
+	list, ok := ReadJSONFile(path).([]interface{})
+	if !ok {
+		db.quarantine(path)
+		return nil
+	}
+	return list
 }
 func (db *Storage) readSecret(path string) []byte {
 	data := ReadBinaryFile(path)
 	if data == nil {
 		return nil
+	}
+	plaintext := db.Password().Decrypt(data)
+	if plaintext == nil {
+		// NOTICE: don't quarantine it, the password may be wrong
+		db.error("Failed to decrypt file: " + path)
+	}
+	return plaintext
+}
+// decrypted but not a valid JSON, the file will be moved aside
+func (db *Storage) readSecretJSON(path string) interface{} {
+	data := db.readSecret(path)
+	if data == nil {
+		return nil
+	}
+	var object interface{}
+	if json.Unmarshal(data, &object) != nil {
+		db.quarantine(path)
+		return nil
+	}
+	return object
+}
+func (db *Storage) readSecretMap(path string) map[string]interface{} {
+	object := db.readSecretJSON(path)
+	if object == nil {
+		return nil
+	}
+	info, ok := object.(map[string]interface{})
+	if !ok {
+		db.quarantine(path)
+		return nil
+	}
+	return info
+}
+func (db *Storage) readSecretList(path string) []interface{} {
+	object := db.readSecretJSON(path)
+	if object == nil {
+		return nil
+	}
+	list, ok := object.([]interface{})
+	if !ok {
+		db.quarantine(path)
+		return nil
+	}
+	return list
+}
+
+// decode JSON text without panic, return nil when it's broken,
+// e.g. the last line truncated by a crash while appending
+func decodeJSONMap(text string) map[string]interface{} {
+	var info map[string]interface{}
+	if json.Unmarshal([]byte(text), &info) != nil {
+		return nil
+	}
+	return info
+}
+func decodeJSONList(text string) []interface{} {
+	var list []interface{}
+	if json.Unmarshal([]byte(text), &list) != nil {
+		return nil
+	}
+	return list
+}
+
+// move the corrupt file aside, so it won't be treated as missing silently
+func (db *Storage) quarantine(path string) {
+	target := PathQuarantine(path)
+	if target == "" {
+		db.error("Corrupt file: " + path)
 	} else {
-		return db._password.Decrypt(data)
+		db.error("Corrupt file moved: " + path + " -> " + target)
 	}
 }
 
@@ -215,9 +453,16 @@ func (db *Storage) writeMap(path string, container interface{}) bool {
 		panic(path)
 	}
 }
+func (db *Storage) appendText(path string, text string) bool {
+	if db.prepareDir(path) {
+		return AppendTextFile(path, text)
+	} else {
+		panic(path)
+	}
+}
 func (db *Storage) writeSecret(path string, data []byte) bool {
 	if db.prepareDir(path) {
-		return WriteBinaryFile(path, db._password.Encrypt(data))
+		return WriteBinaryFile(path, db.Password().Encrypt(data))
 	} else {
 		panic(path)
 	}
@@ -247,15 +492,101 @@ func (db *Storage) error(msg string) {
 	LogError(msg)
 }
 
+/**
+ *  Open database
+ *
+ * @param driver - "file" for local storage, "memory" for memory storage,
+ *                 or SQL driver name
+ * @param source - root directory for local storage, or SQL data source name
+ * @return nil on failed
+ */
+func OpenDatabase(driver string, source string) Database {
+	if driver == "memory" {
+		return NewMemoryStorage()
+	} else if driver == "" || driver == "file" {
+		storage := new(Storage).Init()
+		if source != "" {
+			storage.SetRoot(source)
+		}
+		return storage
+	}
+	storage := new(SQLStorage).Init(driver, source)
+	if storage == nil {
+		return nil
+	}
+	return storage
+}
+
 //
 //  Singleton
 //
 var sharedDatabase Database
+var sharedDatabaseOnce sync.Once
 
+/**
+ *  Get shared database, it will be opened with environment variables at first call,
+ *  so the SQL driver imported by the application is registered already
+ */
 func SharedDatabase() Database {
+	sharedDatabaseOnce.Do(func() {
+		sharedDatabase = openSharedDatabase()
+	})
 	return sharedDatabase
 }
 
-func init() {
-	sharedDatabase = new(Storage).Init()
+/**
+ *  Replace the shared database, should be called before first use
+ */
+func SetSharedDatabase(db Database) {
+	sharedDatabaseOnce.Do(func() {
+		// don't open the default one
+	})
+	sharedDatabase = db
+}
+
+/**
+ *  Database can be selected by environment variables:
+ *
+ *      DIM_DB_DRIVER - "file" (default), "memory", or SQL driver name, e.g. "sqlite3"
+ *                      (SQL driver must be imported by the application)
+ *      DIM_DB_SOURCE - root directory, or SQL data source name
+ *      DIM_ROOT - root directory for local storage, when DIM_DB_SOURCE not set
+ *      DIM_PASSPHRASE - passphrase for keystore
+ *      DIM_CACHE_SIZE - max records for each memory cache of local storage
+ *      DIM_CACHE_NEGATIVE_TTL - seconds to keep 'not found' records in caches
+ *      DIM_PROVIDERS - JSON file to import service providers & stations at first start
+ */
+func openSharedDatabase() Database {
+	driver := os.Getenv("DIM_DB_DRIVER")
+	source := os.Getenv("DIM_DB_SOURCE")
+	if source == "" && (driver == "" || driver == "file") {
+		source = os.Getenv("DIM_ROOT")
+	}
+	db := OpenDatabase(driver, source)
+	if db == nil {
+		panic("failed to open database: " + driver + ", only 'file' & 'memory' supported by default, " +
+			"to use SQL driver, import it in the application, e.g.: import _ \"github.com/mattn/go-sqlite3\"")
+	}
+	// cache limits
+	size, _ := strconv.Atoi(os.Getenv("DIM_CACHE_SIZE"))
+	ttl, _ := strconv.Atoi(os.Getenv("DIM_CACHE_NEGATIVE_TTL"))
+	if storage, ok := db.(*Storage); ok && (size > 0 || ttl > 0) {
+		if size <= 0 {
+			size = DefaultCacheSize
+		}
+		if ttl <= 0 {
+			ttl = int(DefaultNegativeTTL / time.Second)
+		}
+		storage.SetCacheLimits(size, time.Duration(ttl) * time.Second)
+	}
+	// service providers
+	if config, ok := db.(ProvidersConfig); ok {
+		config.SetProvidersConfig(os.Getenv("DIM_PROVIDERS"))
+	}
+	// unlock keystore
+	password := os.Getenv("DIM_PASSPHRASE")
+	if keystore, ok := db.(Keystore); ok && password != "" {
+		keystore.SetPassword(password)
+	}
+	return db
 }
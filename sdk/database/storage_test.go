@@ -0,0 +1,412 @@
+/* license: https://mit-license.org
+ * ==============================================================================
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2021 Albert Moky
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ * ==============================================================================
+ */
+package db
+
+import (
+	"fmt"
+	. "github.com/dimchat/demo-go/sdk/common/db"
+	. "github.com/dimchat/demo-go/sdk/extensions"
+	. "github.com/dimchat/dkd-go/protocol"
+	. "github.com/dimchat/mkm-go/crypto"
+	. "github.com/dimchat/mkm-go/format"
+	. "github.com/dimchat/mkm-go/protocol"
+	_ "github.com/dimchat/sdk-go/dimp/cpu"
+	_ "github.com/dimchat/sdk-go/plugins"
+	. "github.com/dimchat/sdk-go/plugins/types"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+/**
+ *  Concurrent access tests, run with race detector:
+ *
+ *      go test -race ./sdk/database/
+ */
+
+const (
+	testWorkers = 8
+	testRounds = 20
+)
+
+func prepareUsers(t *testing.T, db Database, count int) []*UserInfo {
+	users := make([]*UserInfo, 0, count)
+	for index := 0; index < count; index++ {
+		info := GenerateUserInfo(fmt.Sprintf("user%d", index), "")
+		if db.SaveMeta(info.Meta, info.ID) == false {
+			t.Fatalf("failed to save meta: %s", info.ID)
+		}
+		users = append(users, info)
+	}
+	return users
+}
+
+// run each task in several goroutines, repeat it in rounds
+func runConcurrently(tasks ...func(round int)) {
+	var wg sync.WaitGroup
+	for worker := 0; worker < testWorkers; worker++ {
+		for _, task := range tasks {
+			wg.Add(1)
+			go func(task func(round int)) {
+				defer wg.Done()
+				for round := 0; round < testRounds; round++ {
+					task(round)
+				}
+			}(task)
+		}
+	}
+	wg.Wait()
+}
+
+func hammerDatabase(t *testing.T, db Database) {
+	users := prepareUsers(t, db, 4)
+	ids := make([]ID, 0, len(users))
+	for _, info := range users {
+		ids = append(ids, info.ID)
+	}
+	group := GenerateGroupInfo(users[0], "Test Group", "").ID
+
+	runConcurrently(
+		// local users
+		func(round int) {
+			user := ids[round % len(ids)]
+			db.AddUser(user)
+			db.SetCurrentUser(user)
+			if db.GetCurrentUser() == nil {
+				t.Error("current user not found")
+			}
+			_ = db.AllUsers()
+		},
+		// documents
+		func(round int) {
+			info := users[round % len(users)]
+			db.SaveDocument(info.Visa)
+			if db.GetDocument(info.ID, VISA) == nil {
+				t.Errorf("visa not found: %s", info.ID)
+			}
+		},
+		// group members
+		func(round int) {
+			members := make([]ID, 0, len(ids))
+			members = append(members, ids[:round % len(ids) + 1]...)
+			db.SaveMembers(members, group)
+			db.AddMember(ids[len(ids) - 1], group)
+			if len(db.GetMembers(group)) == 0 {
+				t.Errorf("members not found: %s", group)
+			}
+		},
+		// private keys
+		func(round int) {
+			info := users[round % len(users)]
+			db.SavePrivateKey(info.ID, info.IdentityKey.(PrivateKey), META_KEY, true, false)
+			db.SavePrivateKey(info.ID, info.CommunicationKey.(PrivateKey), VISA_KEY, true, true)
+			if db.GetPrivateKeyForSignature(info.ID) == nil {
+				t.Errorf("sign key not found: %s", info.ID)
+			}
+			if db.GetPrivateKeyForVisaSignature(info.ID) == nil {
+				t.Errorf("identity key not found: %s", info.ID)
+			}
+			if len(db.GetPrivateKeysForDecryption(info.ID)) == 0 {
+				t.Errorf("decrypt keys not found: %s", info.ID)
+			}
+		},
+	)
+
+	// check results
+	all := db.AllUsers()
+	if len(all) != len(ids) {
+		t.Fatalf("local users error: %v", all)
+	}
+	for _, user := range ids {
+		if !containsID(all, user) {
+			t.Errorf("local user lost: %s", user)
+		}
+	}
+	members := db.GetMembers(group)
+	for index, item := range members {
+		if containsID(members[index+1:], item) {
+			t.Errorf("duplicated member: %s", item)
+		}
+	}
+}
+
+func TestStorageConcurrentAccess(t *testing.T) {
+	storage := new(Storage).Init()
+	storage.SetRoot(t.TempDir())
+	hammerDatabase(t, storage)
+
+	// reload from files
+	reloaded := new(Storage).Init()
+	reloaded.SetRoot(storage.Root())
+	if len(reloaded.AllUsers()) != len(storage.AllUsers()) {
+		t.Fatalf("local users not saved: %v", reloaded.AllUsers())
+	}
+	for _, user := range reloaded.AllUsers() {
+		if reloaded.GetPrivateKeyForVisaSignature(user) == nil {
+			t.Errorf("identity key not saved: %s", user)
+		}
+		if reloaded.GetDocument(user, VISA) == nil {
+			t.Errorf("visa not saved: %s", user)
+		}
+	}
+}
+
+func TestMemoryStorageConcurrentAccess(t *testing.T) {
+	hammerDatabase(t, NewMemoryStorage())
+}
+
+// distinct members added concurrently, none of them can be lost
+func addMembersConcurrently(t *testing.T, db Database, count int) (ID, []ID) {
+	users := prepareUsers(t, db, 1)
+	group := GenerateGroupInfo(users[0], "Test Group", "").ID
+	members := make([]ID, 0, count)
+	for index := 0; index < count; index++ {
+		members = append(members, GenerateUserInfo(fmt.Sprintf("member%d", index), "").ID)
+	}
+	var wg sync.WaitGroup
+	for _, item := range members {
+		wg.Add(1)
+		go func(member ID) {
+			defer wg.Done()
+			if db.AddMember(member, group) == false {
+				t.Errorf("failed to add member: %s", member)
+			}
+		}(item)
+	}
+	wg.Wait()
+	return group, members
+}
+
+func checkMembers(t *testing.T, db Database, group ID, members []ID) {
+	results := db.GetMembers(group)
+	if len(results) != len(members) {
+		t.Errorf("members count error: %d, %d", len(results), len(members))
+	}
+	for _, item := range members {
+		if !containsID(results, item) {
+			t.Errorf("member lost: %s", item)
+		}
+	}
+}
+
+func TestStorageLostUpdate(t *testing.T) {
+	storage := new(Storage).Init()
+	storage.SetRoot(t.TempDir())
+	group, members := addMembersConcurrently(t, storage, testWorkers * 4)
+	checkMembers(t, storage, group, members)
+
+	// reload from files, nothing corrupted
+	reloaded := new(Storage).Init()
+	reloaded.SetRoot(storage.Root())
+	checkMembers(t, reloaded, group, members)
+	if history := reloaded.GetMembershipHistory(group); len(history) != len(members) {
+		t.Errorf("membership history lost: %d", len(history))
+	}
+	_ = filepath.Walk(storage.Root(), func(path string, info os.FileInfo, err error) error {
+		if err == nil && strings.Contains(info.Name(), ".corrupt-") {
+			t.Errorf("file corrupted: %s", path)
+		}
+		return nil
+	})
+}
+
+func TestMemoryStorageLostUpdate(t *testing.T) {
+	storage := NewMemoryStorage()
+	group, members := addMembersConcurrently(t, storage, testWorkers * 4)
+	checkMembers(t, storage, group, members)
+}
+
+func newTestMessage(sender ID, receiver ID, text string) InstantMessage {
+	content := make(map[string]interface{})
+	content["type"] = 1
+	content["sn"] = time.Now().UnixNano() % 0x7FFFFFFF
+	content["text"] = text
+	info := make(map[string]interface{})
+	info["sender"] = sender.String()
+	info["receiver"] = receiver.String()
+	info["time"] = time.Now().Unix()
+	info["content"] = content
+	return InstantMessageParse(info)
+}
+
+func TestStorageTruncatedMessage(t *testing.T) {
+	storage := new(Storage).Init()
+	storage.SetRoot(t.TempDir())
+	users := prepareUsers(t, storage, 2)
+	sender, receiver := users[0].ID, users[1].ID
+	for _, text := range []string{"Hello", "World"} {
+		if storage.InsertMessage(newTestMessage(sender, receiver, text), sender) == false {
+			t.Fatalf("failed to insert message: %s", text)
+		}
+	}
+
+	// crash while appending the third message
+	path := messagesPath(storage, sender)
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = file.WriteString(`{"sender": "` + sender.String() + `", "content": {"ty`)
+	_ = file.Close()
+
+	reloaded := new(Storage).Init()
+	reloaded.SetRoot(storage.Root())
+	if count := reloaded.NumberOfMessages(sender); count != 2 {
+		t.Fatalf("messages not recovered: %d", count)
+	}
+	corrupt, _ := filepath.Glob(path + ".corrupt-*")
+	if len(corrupt) != 1 {
+		t.Fatalf("broken file not quarantined: %v", corrupt)
+	}
+
+	// valid lines saved back
+	again := new(Storage).Init()
+	again.SetRoot(storage.Root())
+	if count := again.NumberOfMessages(sender); count != 2 {
+		t.Fatalf("valid messages not saved back: %d", count)
+	}
+}
+
+func TestKeystoreUpgrade(t *testing.T) {
+	N := ScryptN
+	ScryptN = 1 << 10
+	defer func() {
+		ScryptN = N
+	}()
+	storage := new(Storage).Init()
+	storage.SetRoot(t.TempDir())
+	info := prepareUsers(t, storage, 1)[0]
+
+	// keystore & secrets created by old version, with the IV derived by scrypt
+	salt := RandomBytes(16)
+	legacy := DeriveLegacyPassword("secret", salt, ScryptN, ScryptR, ScryptP)
+	keystore := make(map[string]interface{})
+	keystore["kdf"] = "scrypt"
+	keystore["salt"] = Base64Encode(salt)
+	keystore["N"] = ScryptN
+	keystore["r"] = ScryptR
+	keystore["p"] = ScryptP
+	keystore["check"] = Base64Encode(legacy.Encrypt(UTF8Encode(keystoreCheckText)))
+	if saveKeystore(storage, keystore) == false {
+		t.Fatal("failed to save keystore")
+	}
+	storage.setPassword(legacy)
+	if storage.SavePrivateKey(info.ID, info.IdentityKey.(PrivateKey), META_KEY, true, false) == false {
+		t.Fatal("failed to save private key")
+	}
+
+	// unlock & upgrade
+	reloaded := new(Storage).Init()
+	reloaded.SetRoot(storage.Root())
+	if reloaded.SetPassword("secret") == false {
+		t.Fatal("failed to unlock legacy keystore")
+	}
+	if keystoreOutdated(reloaded.readMap(keystorePath(reloaded))) {
+		t.Fatal("keystore not upgraded")
+	}
+	if reloaded.GetPrivateKeyForVisaSignature(info.ID) == nil {
+		t.Fatal("private key lost after upgrade")
+	}
+	again := new(Storage).Init()
+	again.SetRoot(storage.Root())
+	if again.SetPassword("wrong") {
+		t.Fatal("unlocked with wrong password")
+	}
+	if again.SetPassword("secret") == false || again.GetPrivateKeyForVisaSignature(info.ID) == nil {
+		t.Fatal("failed to unlock upgraded keystore")
+	}
+}
+
+func TestProvidersImport(t *testing.T) {
+	storage := new(Storage).Init()
+	storage.SetRoot(t.TempDir())
+	config := filepath.Join(t.TempDir(), "providers.json")
+	storage.SetProvidersConfig(config)
+	// config not ready yet
+	if len(storage.GetProviders()) != 0 {
+		t.Fatal("providers imported from nothing")
+	}
+
+	sp := GenerateUserInfo("sp", "").ID
+	s1 := GenerateStationInfo("s1", "Station 1", "", "127.0.0.1", 9394).ID
+	s2 := GenerateStationInfo("s2", "Station 2", "", "127.0.0.1", 9395).ID
+	text := `[{"ID": "` + sp.String() + `", "name": "SP", "stations": [` +
+		`{"ID": "` + s1.String() + `", "host": "127.0.0.1", "port": 9394},` +
+		`{"ID": "` + s2.String() + `", "host": "127.0.0.1", "port": 70000}]}]`
+	if err := os.WriteFile(config, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// retry on next access
+	providers := storage.GetProviders()
+	if len(providers) != 1 || providers[0].ID.Equal(sp) == false || providers[0].Chosen == false {
+		t.Fatalf("providers not imported: %v", providers)
+	}
+	stations := storage.GetStations(sp)
+	if len(stations) != 1 || stations[0].ID.Equal(s1) == false || stations[0].Port != 9394 {
+		t.Fatalf("station with invalid port imported: %v", stations)
+	}
+	// saved
+	reloaded := new(Storage).Init()
+	reloaded.SetRoot(storage.Root())
+	if len(reloaded.GetProviders()) != 1 || len(reloaded.GetStations(sp)) != 1 {
+		t.Fatal("providers not saved")
+	}
+}
+
+func TestGroupReturnsCopies(t *testing.T) {
+	storage := new(Storage).Init()
+	storage.SetRoot(t.TempDir())
+	users := prepareUsers(t, storage, 3)
+	group := GenerateGroupInfo(users[0], "Test Group", "").ID
+	bots := []ID{users[1].ID}
+	if storage.SaveAssistants(bots, group) == false || storage.AddMember(users[2].ID, group) == false {
+		t.Fatal("failed to save group info")
+	}
+	for _, db := range []Database{storage, NewMemoryStorage()} {
+		db.SaveAssistants(bots, group)
+		db.SaveMembers([]ID{users[2].ID}, group)
+		// modify the returned lists
+		db.GetAssistants(group)[0] = users[0].ID
+		db.GetMembers(group)[0] = users[0].ID
+		if db.GetAssistants(group)[0].Equal(users[1].ID) == false {
+			t.Errorf("assistants modified from outside: %T", db)
+		}
+		if db.GetMembers(group)[0].Equal(users[2].ID) == false {
+			t.Errorf("members modified from outside: %T", db)
+		}
+	}
+	history := storage.GetMembershipHistory(group)
+	if len(history) != 1 || history[0]["action"] != "add" || history[0]["member"] != users[2].ID.String() {
+		t.Fatalf("membership history error: %v", history)
+	}
+	history[0]["action"] = "remove"
+	if storage.GetMembershipHistory(group)[0]["action"] != "add" {
+		t.Error("membership history modified from outside")
+	}
+}
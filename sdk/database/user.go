@@ -25,53 +25,116 @@
  */
 package db
 
-import . "github.com/dimchat/mkm-go/protocol"
+import (
+	. "github.com/dimchat/demo-go/sdk/utils"
+	. "github.com/dimchat/mkm-go/protocol"
+)
 
 //-------- UserTable
 
 func (db *Storage) AllUsers() []ID {
-	return db._users
+	db._usersLock.Lock()
+	defer db._usersLock.Unlock()
+	return copyIDList(getUsers(db))
 }
 
 func (db *Storage) AddUser(user ID) bool {
-	for _, id := range db._users {
+	db._usersLock.Lock()
+	defer db._usersLock.Unlock()
+	arr := getUsers(db)
+	for _, id := range arr {
 		if user.Equal(id) {
 			return false
 		}
 	}
-	users := make([]ID, 0, len(db._users)+1)
+	users := make([]ID, 0, len(arr)+1)
 	users = append(users, user)
-	users = append(users, db._users...)
-	db._users = users
-	return true
+	users = append(users, arr...)
+	return setUsers(db, users)
 }
 
 func (db *Storage) RemoveUser(user ID) bool {
-	var pos = -1
-	for index, id := range db._users {
-		if user.Equal(id) {
-			pos = index
-			break
-		}
-	}
-	if pos == -1 {
+	db._usersLock.Lock()
+	defer db._usersLock.Unlock()
+	users, ok := removeID(getUsers(db), user)
+	if !ok {
 		// user ID not found
 		return false
-	} else {
-		db._users = append(db._users[:pos], db._users[pos+1:]...)
-		return true
 	}
+	return setUsers(db, users)
 }
 
 func (db *Storage) SetCurrentUser(user ID) {
-	db.RemoveUser(user)
-	db.AddUser(user)
+	db._usersLock.Lock()
+	defer db._usersLock.Unlock()
+	arr := getUsers(db)
+	users := make([]ID, 0, len(arr)+1)
+	users = append(users, user)
+	for _, id := range arr {
+		if user.Equal(id) == false {
+			users = append(users, id)
+		}
+	}
+	setUsers(db, users)
 }
 
 func (db *Storage) GetCurrentUser() ID {
-	if len(db._users) > 0 {
-		return db._users[0]
+	arr := db.AllUsers()
+	if len(arr) > 0 {
+		return arr[0]
 	} else {
 		return nil
 	}
 }
+
+// NOTICE: caller must hold the users lock
+func getUsers(db *Storage) []ID {
+	if db._users == nil {
+		db._users = loadUsers(db)
+	}
+	return db._users
+}
+
+func setUsers(db *Storage, users []ID) bool {
+	db._users = users
+	return saveUsers(db, users)
+}
+
+/**
+ *  Users file for local accounts
+ *  ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+ *
+ *  file path: '.dim/private/users.txt'
+ *
+ *  the first line is the current user
+ */
+
+func usersPath(db *Storage) string {
+	return PathJoin(db.Root(), "private", "users.txt")
+}
+
+func loadUsers(db *Storage) []ID {
+	path := usersPath(db)
+	db.log("Loading local users: " + path)
+	users := make([]ID, 0)
+	db.readLines(path, func(line string) bool {
+		id := IDParse(line)
+		if id == nil {
+			return false
+		}
+		users = append(users, id)
+		return true
+	})
+	return users
+}
+
+func saveUsers(db *Storage, users []ID) bool {
+	text := ""
+	lines := IDRevert(users)
+	for _, rec := range lines {
+		text = text + rec + "\n"
+	}
+	path := usersPath(db)
+	db.log("Saving local users: " + path)
+	return db.writeText(path, text)
+}
@@ -0,0 +1,186 @@
+/* license: https://mit-license.org
+ * ==============================================================================
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2021 Albert Moky
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ * ==============================================================================
+ */
+package dimp
+
+import (
+	. "github.com/dimchat/demo-go/sdk/utils"
+	. "github.com/dimchat/mkm-go/crypto"
+	. "github.com/dimchat/mkm-go/format"
+	. "github.com/dimchat/mkm-go/protocol"
+	. "github.com/dimchat/sdk-go/plugins/types"
+)
+
+/**
+ *  Account Bundle
+ *  ~~~~~~~~~~~~~~
+ *
+ *  For moving account between machines
+ *
+ *  format: {
+ *      ID        : "{USER_ID}",
+ *      version   : 2,           // 2: random IV before the encrypted data
+ *      kdf       : "scrypt",
+ *      salt      : "{BASE64}",
+ *      N         : 32768,
+ *      r         : 8,
+ *      p         : 1,
+ *      data      : "{BASE64}",  // IV + encrypted JSON with meta, visa & private keys
+ *      signature : "{BASE64}"   // signature of the encrypted data by identity key
+ *  }
+ *
+ *  content: {
+ *      meta               : {...},
+ *      visa               : {...},
+ *      identity_key       : {...},
+ *      communication_keys : [{...}, ...]  // latest first
+ *  }
+ */
+const bundleVersion = 2
+
+type AccountBundle struct {
+
+	ID ID
+
+	Meta Meta
+	Visa Document
+
+	IdentityKey PrivateKey
+	CommunicationKeys []PrivateKey
+}
+
+/**
+ *  Encrypt account info with passphrase and sign it with identity key
+ *
+ * @param bundle   - account info
+ * @param password - passphrase
+ * @return bundle info
+ */
+func AccountBundleSeal(bundle *AccountBundle, password string) map[string]interface{} {
+	// 1. pack content
+	keys := make([]interface{}, 0, len(bundle.CommunicationKeys))
+	for _, item := range bundle.CommunicationKeys {
+		keys = append(keys, item.Map())
+	}
+	content := make(map[string]interface{})
+	content["meta"] = bundle.Meta.Map()
+	content["visa"] = bundle.Visa.Map()
+	content["identity_key"] = bundle.IdentityKey.Map()
+	content["communication_keys"] = keys
+	// 2. encrypt with password
+	salt := RandomBytes(16)
+	key := DerivePassword(password, salt, ScryptN, ScryptR, ScryptP)
+	if key == nil {
+		return nil
+	}
+	data := key.Encrypt(UTF8Encode(JSONEncodeMap(content)))
+	// 3. sign with identity key
+	signature := bundle.IdentityKey.Sign(data)
+	info := make(map[string]interface{})
+	info["ID"] = bundle.ID.String()
+	info["version"] = bundleVersion
+	info["kdf"] = "scrypt"
+	info["salt"] = Base64Encode(salt)
+	info["N"] = ScryptN
+	info["r"] = ScryptR
+	info["p"] = ScryptP
+	info["data"] = Base64Encode(data)
+	info["signature"] = Base64Encode(signature)
+	return info
+}
+
+/**
+ *  Decrypt account info with passphrase,
+ *  and verify the bundle signature with meta key
+ *
+ *  NOTICE: the meta and visa are NOT verified with ID here,
+ *          but the identity key is checked with the meta key
+ *
+ * @param info     - bundle info
+ * @param password - passphrase
+ * @return nil on wrong passphrase or invalid signature
+ */
+func AccountBundleOpen(info map[string]interface{}, password string) *AccountBundle {
+	identifier := IDParse(info["ID"])
+	if identifier == nil || info["kdf"] != "scrypt" {
+		return nil
+	}
+	// 1. decrypt with password
+	salt, _ := info["salt"].(string)
+	N, ok1 := ToInt64(info["N"])
+	r, ok2 := ToInt64(info["r"])
+	p, ok3 := ToInt64(info["p"])
+	if !ok1 || !ok2 || !ok3 || !ScryptParamsValid(int(N), int(r), int(p)) {
+		return nil
+	}
+	var key SymmetricKey
+	if version, _ := ToInt64(info["version"]); version >= bundleVersion {
+		key = DerivePassword(password, Base64Decode(salt), int(N), int(r), int(p))
+	} else {
+		// exported by old version, without IV
+		key = DeriveLegacyPassword(password, Base64Decode(salt), int(N), int(r), int(p))
+	}
+	if key == nil {
+		return nil
+	}
+	b64, _ := info["data"].(string)
+	data := Base64Decode(b64)
+	plaintext := key.Decrypt(data)
+	if plaintext == nil {
+		return nil
+	}
+	content := JSONDecodeMap(UTF8Decode(plaintext))
+	if content == nil {
+		return nil
+	}
+	// 2. parse content
+	bundle := new(AccountBundle)
+	bundle.ID = identifier
+	bundle.Meta = MetaParse(content["meta"])
+	bundle.Visa = DocumentParse(content["visa"])
+	bundle.IdentityKey = PrivateKeyParse(content["identity_key"])
+	if bundle.Meta == nil || bundle.Visa == nil || bundle.IdentityKey == nil {
+		return nil
+	}
+	// identity key must match the meta key
+	challenge := RandomBytes(32)
+	if bundle.Meta.Key().Verify(challenge, bundle.IdentityKey.Sign(challenge)) == false {
+		return nil
+	}
+	keys, _ := content["communication_keys"].([]interface{})
+	for _, item := range keys {
+		msgKey := PrivateKeyParse(item)
+		if msgKey != nil {
+			bundle.CommunicationKeys = append(bundle.CommunicationKeys, msgKey)
+		}
+	}
+	// 3. verify signature with meta key
+	b64, _ = info["signature"].(string)
+	signature := Base64Decode(b64)
+	if bundle.Meta.Key().Verify(data, signature) == false {
+		return nil
+	}
+	return bundle
+}
@@ -37,6 +37,9 @@ var BlockSize = 16
 
 /**
  *  This is for generating symmetric key with a text string
+ *
+ *  NOTICE: it's used for storage command between clients,
+ *          use 'DerivePassword' for local keystore instead.
  */
 func GeneratePassword(password string) SymmetricKey {
 	data := UTF8Encode(password)
@@ -62,3 +65,107 @@ func GeneratePassword(password string) SymmetricKey {
 	key["iv"] = Base64Encode(iv)
 	return SymmetricKeyParse(key)
 }
+
+/**
+ *  Parameters for deriving password from passphrase
+ */
+var ScryptN = 1 << 15
+var ScryptR = 8
+var ScryptP = 1
+
+/**
+ *  Limits for parameters loaded from files (keystore, account bundle),
+ *  so a crafted file cannot exhaust memory or CPU
+ */
+const (
+	ScryptMaxN = 1 << 20
+	ScryptMaxR = 32
+	ScryptMaxP = 16
+)
+
+// N must be a power of two
+func ScryptParamsValid(N, r, p int) bool {
+	return N > 1 && N <= ScryptMaxN && N & (N - 1) == 0 &&
+		r > 0 && r <= ScryptMaxR && p > 0 && p <= ScryptMaxP
+}
+
+/**
+ *  This is for generating symmetric key with a passphrase and random salt,
+ *  derived by memory-hard KDF (scrypt)
+ *
+ *  NOTICE: only the key data is derived, a random IV is generated
+ *          for each encryption, and stored before the ciphertext:
+ *
+ *              {IV}{CIPHERTEXT}
+ */
+func DerivePassword(password string, salt []byte, N, r, p int) SymmetricKey {
+	if ScryptParamsValid(N, r, p) == false {
+		return nil
+	}
+	derived := Scrypt(UTF8Encode(password), salt, N, r, p, KeySize)
+	if derived == nil {
+		return nil
+	}
+	key := new(passwordKey)
+	key.Init(derived)
+	return key
+}
+
+/**
+ *  Key derived with IV by old version, for data encrypted without IV stored
+ *
+ *  NOTICE: the same IV is used for all encryptions, only use it for decrypting
+ */
+func DeriveLegacyPassword(password string, salt []byte, N, r, p int) SymmetricKey {
+	if ScryptParamsValid(N, r, p) == false {
+		return nil
+	}
+	derived := Scrypt(UTF8Encode(password), salt, N, r, p, KeySize + BlockSize)
+	if derived == nil {
+		return nil
+	}
+	return aesKey(derived[:KeySize], derived[KeySize:])
+}
+
+func aesKey(data []byte, iv []byte) SymmetricKey {
+	key := make(map[string]interface{})
+	key["algorithm"] = AES
+	key["data"] = Base64Encode(data)
+	key["iv"] = Base64Encode(iv)
+	return SymmetricKeyParse(key)
+}
+
+/**
+ *  AES Key with random IV for each encryption
+ */
+type passwordKey struct {
+	SymmetricKey
+
+	_data []byte
+}
+
+func (key *passwordKey) Init(data []byte) *passwordKey {
+	key._data = data
+	// the embedded key is for algorithm & key info only,
+	// encryption always uses a new IV
+	key.SymmetricKey = aesKey(data, make([]byte, BlockSize))
+	return key
+}
+
+func (key *passwordKey) Encrypt(plaintext []byte) []byte {
+	iv := RandomBytes(BlockSize)
+	ciphertext := aesKey(key._data, iv).Encrypt(plaintext)
+	if ciphertext == nil {
+		return nil
+	}
+	data := make([]byte, 0, BlockSize + len(ciphertext))
+	data = append(data, iv...)
+	return append(data, ciphertext...)
+}
+
+func (key *passwordKey) Decrypt(ciphertext []byte) []byte {
+	if len(ciphertext) <= BlockSize {
+		return nil
+	}
+	return aesKey(key._data, ciphertext[:BlockSize]).Decrypt(ciphertext[BlockSize:])
+}
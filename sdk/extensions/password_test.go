@@ -0,0 +1,130 @@
+/* license: https://mit-license.org
+ * ==============================================================================
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2021 Albert Moky
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ * ==============================================================================
+ */
+package dimp
+
+import (
+	"bytes"
+	. "github.com/dimchat/mkm-go/crypto"
+	. "github.com/dimchat/mkm-go/format"
+	. "github.com/dimchat/sdk-go/plugins/types"
+	"testing"
+)
+
+// fast parameters for tests
+const (
+	testScryptN = 1 << 10
+	testScryptR = 8
+	testScryptP = 1
+)
+
+func TestDerivePasswordRandomIV(t *testing.T) {
+	salt := RandomBytes(16)
+	key := DerivePassword("secret", salt, testScryptN, testScryptR, testScryptP)
+	if key == nil {
+		t.Fatal("failed to derive password")
+	}
+	plaintext := []byte("Hello world!")
+	data1 := key.Encrypt(plaintext)
+	data2 := key.Encrypt(plaintext)
+	if bytes.Equal(data1, data2) || bytes.Equal(data1[:BlockSize], data2[:BlockSize]) {
+		t.Fatal("IV reused")
+	}
+	// derive again
+	key = DerivePassword("secret", salt, testScryptN, testScryptR, testScryptP)
+	for _, data := range [][]byte{data1, data2} {
+		if !bytes.Equal(key.Decrypt(data), plaintext) {
+			t.Fatal("failed to decrypt")
+		}
+	}
+	wrong := DerivePassword("wrong", salt, testScryptN, testScryptR, testScryptP)
+	if bytes.Equal(wrong.Decrypt(data1), plaintext) {
+		t.Fatal("decrypted with wrong password")
+	}
+	if DerivePassword("secret", salt, 1000, testScryptR, testScryptP) != nil {
+		t.Error("N must be a power of two")
+	}
+}
+
+func TestDeriveLegacyPassword(t *testing.T) {
+	salt := RandomBytes(16)
+	legacy := DeriveLegacyPassword("secret", salt, testScryptN, testScryptR, testScryptP)
+	key, _ := DerivePassword("secret", salt, testScryptN, testScryptR, testScryptP).(*passwordKey)
+	if legacy == nil || key == nil {
+		t.Fatal("failed to derive password")
+	}
+	// the key data is the same, only the IV is not derived
+	if legacy.Get("data") != Base64Encode(key._data) {
+		t.Fatal("key data not compatible")
+	}
+	plaintext := []byte("Hello world!")
+	if !bytes.Equal(legacy.Decrypt(legacy.Encrypt(plaintext)), plaintext) {
+		t.Fatal("failed to decrypt with legacy key")
+	}
+}
+
+func TestAccountBundle(t *testing.T) {
+	N := ScryptN
+	ScryptN = testScryptN
+	defer func() {
+		ScryptN = N
+	}()
+	info := GenerateUserInfo("user", "")
+	bundle := &AccountBundle{
+		ID: info.ID,
+		Meta: info.Meta,
+		Visa: info.Visa,
+		IdentityKey: info.IdentityKey.(PrivateKey),
+		CommunicationKeys: []PrivateKey{info.CommunicationKey.(PrivateKey)},
+	}
+	sealed := AccountBundleSeal(bundle, "secret")
+	if sealed == nil {
+		t.Fatal("failed to seal bundle")
+	}
+	if AccountBundleOpen(sealed, "wrong") != nil {
+		t.Fatal("opened with wrong password")
+	}
+	opened := AccountBundleOpen(sealed, "secret")
+	if opened == nil || opened.ID.Equal(info.ID) == false || len(opened.CommunicationKeys) != 1 {
+		t.Fatalf("failed to open bundle: %v", opened)
+	}
+
+	// exported by old version: IV derived by scrypt, no version field
+	salt := Base64Decode(sealed["salt"].(string))
+	key := DerivePassword("secret", salt, testScryptN, testScryptR, testScryptP)
+	plaintext := key.Decrypt(Base64Decode(sealed["data"].(string)))
+	legacy := DeriveLegacyPassword("secret", salt, testScryptN, testScryptR, testScryptP)
+	data := legacy.Encrypt(plaintext)
+	old := make(map[string]interface{})
+	for name, value := range sealed {
+		old[name] = value
+	}
+	delete(old, "version")
+	old["data"] = Base64Encode(data)
+	old["signature"] = Base64Encode(bundle.IdentityKey.Sign(data))
+	if opened = AccountBundleOpen(old, "secret"); opened == nil || opened.ID.Equal(info.ID) == false {
+		t.Fatal("failed to open bundle exported by old version")
+	}
+}
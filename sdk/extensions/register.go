@@ -166,9 +166,9 @@ func GenerateStationInfo(seed string, name string, logo string, host string, por
 	//
 	profile := DocumentCreate(PROFILE, identifier, "", "")
 	profile.SetName(name)
-	profile.Set("logo", logo)
-	profile.Set("host", host)
-	profile.Set("port", port)
+	profile.SetProperty("logo", logo)
+	profile.SetProperty("host", host)
+	profile.SetProperty("port", port)
 	profile.Sign(identityKey)
 	//
 	//  OK
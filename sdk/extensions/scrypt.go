@@ -0,0 +1,166 @@
+/* license: https://mit-license.org
+ * ==============================================================================
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2021 Albert Moky
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ * ==============================================================================
+ */
+package dimp
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"math/bits"
+)
+
+/**
+ *  scrypt: memory-hard key derivation function (RFC 7914)
+ *
+ * @param password - passphrase
+ * @param salt     - random salt
+ * @param N        - CPU/memory cost, must be power of 2 and greater than 1
+ * @param r        - block size
+ * @param p        - parallelization
+ * @param keyLen   - derived key length
+ * @return nil on parameters error
+ */
+func Scrypt(password, salt []byte, N, r, p, keyLen int) []byte {
+	if N <= 1 || N&(N-1) != 0 || r <= 0 || p <= 0 || keyLen <= 0 {
+		return nil
+	}
+	blockSize := 128 * r
+	b := pbkdf2SHA256(password, salt, 1, p*blockSize)
+	for i := 0; i < p; i++ {
+		roMix(b[i*blockSize:(i+1)*blockSize], r, N)
+	}
+	return pbkdf2SHA256(password, b, 1, keyLen)
+}
+
+func pbkdf2SHA256(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	hashLen := prf.Size()
+	numBlocks := (keyLen + hashLen - 1) / hashLen
+	var buf [4]byte
+	dk := make([]byte, 0, numBlocks*hashLen)
+	u := make([]byte, hashLen)
+	for block := 1; block <= numBlocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(buf[:], uint32(block))
+		prf.Write(buf[:])
+		dk = prf.Sum(dk)
+		t := dk[len(dk)-hashLen:]
+		copy(u, t)
+		for n := 2; n <= iterations; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for x := range u {
+				t[x] ^= u[x]
+			}
+		}
+	}
+	return dk[:keyLen]
+}
+
+func roMix(b []byte, r, N int) {
+	words := 32 * r
+	x := make([]uint32, words)
+	y := make([]uint32, words)
+	for i := range x {
+		x[i] = binary.LittleEndian.Uint32(b[i*4:])
+	}
+	v := make([]uint32, words*N)
+	for i := 0; i < N; i++ {
+		copy(v[i*words:], x)
+		blockMix(x, y, r)
+		x, y = y, x
+	}
+	for i := 0; i < N; i++ {
+		j := int(x[(2*r-1)*16] & uint32(N-1))
+		for k := 0; k < words; k++ {
+			x[k] ^= v[j*words+k]
+		}
+		blockMix(x, y, r)
+		x, y = y, x
+	}
+	for i, w := range x {
+		binary.LittleEndian.PutUint32(b[i*4:], w)
+	}
+}
+
+func blockMix(b, y []uint32, r int) {
+	var x [16]uint32
+	copy(x[:], b[(2*r-1)*16:])
+	for i := 0; i < 2*r; i++ {
+		for k := 0; k < 16; k++ {
+			x[k] ^= b[i*16+k]
+		}
+		salsa208(&x)
+		// even blocks first, then odd blocks
+		pos := (i/2 + (i%2)*r) * 16
+		copy(y[pos:pos+16], x[:])
+	}
+}
+
+func salsa208(b *[16]uint32) {
+	x := *b
+	for i := 0; i < 8; i += 2 {
+		// columns
+		x[4] ^= bits.RotateLeft32(x[0]+x[12], 7)
+		x[8] ^= bits.RotateLeft32(x[4]+x[0], 9)
+		x[12] ^= bits.RotateLeft32(x[8]+x[4], 13)
+		x[0] ^= bits.RotateLeft32(x[12]+x[8], 18)
+		x[9] ^= bits.RotateLeft32(x[5]+x[1], 7)
+		x[13] ^= bits.RotateLeft32(x[9]+x[5], 9)
+		x[1] ^= bits.RotateLeft32(x[13]+x[9], 13)
+		x[5] ^= bits.RotateLeft32(x[1]+x[13], 18)
+		x[14] ^= bits.RotateLeft32(x[10]+x[6], 7)
+		x[2] ^= bits.RotateLeft32(x[14]+x[10], 9)
+		x[6] ^= bits.RotateLeft32(x[2]+x[14], 13)
+		x[10] ^= bits.RotateLeft32(x[6]+x[2], 18)
+		x[3] ^= bits.RotateLeft32(x[15]+x[11], 7)
+		x[7] ^= bits.RotateLeft32(x[3]+x[15], 9)
+		x[11] ^= bits.RotateLeft32(x[7]+x[3], 13)
+		x[15] ^= bits.RotateLeft32(x[11]+x[7], 18)
+		// rows
+		x[1] ^= bits.RotateLeft32(x[0]+x[3], 7)
+		x[2] ^= bits.RotateLeft32(x[1]+x[0], 9)
+		x[3] ^= bits.RotateLeft32(x[2]+x[1], 13)
+		x[0] ^= bits.RotateLeft32(x[3]+x[2], 18)
+		x[6] ^= bits.RotateLeft32(x[5]+x[4], 7)
+		x[7] ^= bits.RotateLeft32(x[6]+x[5], 9)
+		x[4] ^= bits.RotateLeft32(x[7]+x[6], 13)
+		x[5] ^= bits.RotateLeft32(x[4]+x[7], 18)
+		x[11] ^= bits.RotateLeft32(x[10]+x[9], 7)
+		x[8] ^= bits.RotateLeft32(x[11]+x[10], 9)
+		x[9] ^= bits.RotateLeft32(x[8]+x[11], 13)
+		x[10] ^= bits.RotateLeft32(x[9]+x[8], 18)
+		x[12] ^= bits.RotateLeft32(x[15]+x[14], 7)
+		x[13] ^= bits.RotateLeft32(x[12]+x[15], 9)
+		x[14] ^= bits.RotateLeft32(x[13]+x[12], 13)
+		x[15] ^= bits.RotateLeft32(x[14]+x[13], 18)
+	}
+	for i := range b {
+		b[i] += x[i]
+	}
+}
@@ -0,0 +1,109 @@
+/* license: https://mit-license.org
+ * ==============================================================================
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2021 Albert Moky
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ * ==============================================================================
+ */
+package dimp
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func hexBytes(text string) []byte {
+	data, err := hex.DecodeString(strings.Join(strings.Fields(text), ""))
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// test vectors from RFC 7914, section 11
+func TestPBKDF2SHA256(t *testing.T) {
+	vectors := []struct {
+		password, salt string
+		iterations int
+		expected string
+	}{
+		{"passwd", "salt", 1, `
+			55 ac 04 6e 56 e3 08 9f ec 16 91 c2 25 44 b6 05
+			f9 41 85 21 6d de 04 65 e6 8b 9d 57 c2 0d ac bc
+			49 ca 9c cc f1 79 b6 45 99 16 64 b3 9d 77 ef 31
+			7c 71 b8 45 b1 e3 0b d5 09 11 20 41 d3 a1 97 83`},
+		{"Password", "NaCl", 80000, `
+			4d dc d8 f6 0b 98 be 21 83 0c ee 5e f2 27 01 f9
+			64 1a 44 18 d0 4c 04 14 ae ff 08 87 6b 34 ab 56
+			a1 d4 25 a1 22 58 33 54 9a db 84 1b 51 c9 b3 17
+			6a 27 2b de bb a1 d0 78 47 8f 62 b3 97 f3 3c 8d`},
+	}
+	for _, item := range vectors {
+		expected := hexBytes(item.expected)
+		derived := pbkdf2SHA256([]byte(item.password), []byte(item.salt), item.iterations, len(expected))
+		if !bytes.Equal(derived, expected) {
+			t.Errorf("PBKDF2-HMAC-SHA256 error: %s, %s, %d\n%x", item.password, item.salt, item.iterations, derived)
+		}
+	}
+}
+
+// test vectors from RFC 7914, section 12,
+// the last one (N = 1048576) is skipped for it takes 1 GiB memory
+func TestScrypt(t *testing.T) {
+	vectors := []struct {
+		password, salt string
+		N, r, p int
+		expected string
+	}{
+		{"", "", 16, 1, 1, `
+			77 d6 57 62 38 65 7b 20 3b 19 ca 42 c1 8a 04 97
+			f1 6b 48 44 e3 07 4a e8 df df fa 3f ed e2 14 42
+			fc d0 06 9d ed 09 48 f8 32 6a 75 3a 0f c8 1f 17
+			e8 d3 e0 fb 2e 0d 36 28 cf 35 e2 0c 38 d1 89 06`},
+		{"password", "NaCl", 1024, 8, 16, `
+			fd ba be 1c 9d 34 72 00 78 56 e7 19 0d 01 e9 fe
+			7c 6a d7 cb c8 23 78 30 e7 73 76 63 4b 37 31 62
+			2e af 30 d9 2e 22 a3 88 6f f1 09 27 9d 98 30 da
+			c7 27 af b9 4a 83 ee 6d 83 60 cb df a2 cc 06 40`},
+		{"pleaseletmein", "SodiumChloride", 16384, 8, 1, `
+			70 23 bd cb 3a fd 73 48 46 1c 06 cd 81 fd 38 eb
+			fd a8 fb ba 90 4f 8e 3e a9 b5 43 f6 54 5d a1 f2
+			d5 43 29 55 61 3f 0f cf 62 d4 97 05 24 2a 9a f9
+			e6 1e 85 dc 0d 65 1e 40 df cf 01 7b 45 57 58 87`},
+	}
+	for _, item := range vectors {
+		expected := hexBytes(item.expected)
+		derived := Scrypt([]byte(item.password), []byte(item.salt), item.N, item.r, item.p, len(expected))
+		if !bytes.Equal(derived, expected) {
+			t.Errorf("scrypt error: %q, %q, N=%d, r=%d, p=%d\n%x", item.password, item.salt, item.N, item.r, item.p, derived)
+		}
+	}
+}
+
+func TestScryptParams(t *testing.T) {
+	for _, params := range [][4]int{{0, 1, 1, 32}, {1, 1, 1, 32}, {1000, 1, 1, 32}, {16, 0, 1, 32}, {16, 1, 0, 32}, {16, 1, 1, 0}} {
+		if Scrypt([]byte("password"), []byte("salt"), params[0], params[1], params[2], params[3]) != nil {
+			t.Errorf("invalid parameters accepted: %v", params)
+		}
+	}
+}
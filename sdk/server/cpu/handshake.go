@@ -26,26 +26,72 @@
 package cpu
 
 import (
-	"github.com/dimchat/core-go/dkd"
 	. "github.com/dimchat/core-go/protocol"
 	. "github.com/dimchat/dkd-go/protocol"
+	. "github.com/dimchat/mkm-go/protocol"
+	. "github.com/dimchat/sdk-go/dimp"
 	. "github.com/dimchat/sdk-go/dimp/cpu"
 	. "github.com/dimchat/sdk-go/dimp/protocol"
 )
 
+/**
+ *  Messenger for one client connection
+ */
+type ISessionMessenger interface {
+	IMessenger
+
+	// key of current session
+	SessionKey() string
+
+	// user ID of current session, nil before handshake success
+	SessionID() ID
+
+	// bind current session with user ID, and mark it active;
+	// messages stored for the user will be delivered after the response
+	LoginSession(identifier ID) bool
+
+	// remove stored message after the receiver's receipt received
+	ConfirmDelivery(signature string) bool
+}
+
 type HandshakeCommandProcessor struct {
 	BaseCommandProcessor
 }
 
-func (cpu *HandshakeCommandProcessor) Execute(cmd Command, _ ReliableMessage) Content {
+func NewHandshakeCommandProcessor(facebook IFacebook, messenger IMessenger) *HandshakeCommandProcessor {
+	cpu := new(HandshakeCommandProcessor)
+	cpu.Init(facebook, messenger)
+	return cpu
+}
+
+//-------- IContentProcessor
+
+func (cpu *HandshakeCommandProcessor) Process(content Content, rMsg ReliableMessage) []Content {
+	cmd, _ := content.(Command)
+	return cpu.Execute(cmd, rMsg)
+}
+
+func (cpu *HandshakeCommandProcessor) Execute(cmd Command, rMsg ReliableMessage) []Content {
 	hsCmd, _ := cmd.(HandshakeCommand)
 	message := hsCmd.Message()
 	if message == "DIM?" || message == "DIM!" {
 		// S -> C
-		return dkd.NewTextContent("Handshake command error: " + message)
-	} else {
-		// C -> S: Hello world!
-		//sessionKey := hsCmd.Session()
-		return nil
+		return cpu.RespondText("Handshake command error: " + message, nil)
+	}
+	messenger, ok := cpu.Messenger().(ISessionMessenger)
+	if !ok {
+		return cpu.RespondText("Handshake failed", nil)
+	}
+	// C -> S: Hello world!
+	sessionKey := messenger.SessionKey()
+	if hsCmd.Session() != sessionKey {
+		// first handshake, or session key expired,
+		// ask the client to handshake again with the new session key
+		return cpu.RespondContent(HandshakeCommandAsk(sessionKey))
+	}
+	// session key matched, bind the session with sender
+	if messenger.LoginSession(rMsg.Sender()) == false {
+		return cpu.RespondText("Handshake failed", nil)
 	}
+	return cpu.RespondContent(HandshakeCommandSuccess())
 }
@@ -29,6 +29,7 @@ import (
 	. "github.com/dimchat/core-go/protocol"
 	. "github.com/dimchat/demo-go/sdk/utils"
 	. "github.com/dimchat/dkd-go/protocol"
+	. "github.com/dimchat/sdk-go/dimp"
 	. "github.com/dimchat/sdk-go/dimp/cpu"
 	. "github.com/dimchat/sdk-go/dimp/dkd"
 )
@@ -37,12 +38,25 @@ type LoginCommandProcessor struct {
 	BaseCommandProcessor
 }
 
-func (cpu *LoginCommandProcessor) Execute(cmd Command, rMsg ReliableMessage) Content {
+func NewLoginCommandProcessor(facebook IFacebook, messenger IMessenger) *LoginCommandProcessor {
+	cpu := new(LoginCommandProcessor)
+	cpu.Init(facebook, messenger)
+	return cpu
+}
+
+//-------- IContentProcessor
+
+func (cpu *LoginCommandProcessor) Process(content Content, rMsg ReliableMessage) []Content {
+	cmd, _ := content.(Command)
+	return cpu.Execute(cmd, rMsg)
+}
+
+func (cpu *LoginCommandProcessor) Execute(cmd Command, rMsg ReliableMessage) []Content {
 	sender := rMsg.Sender()
 	info := make(map[string]interface{})
 	info["ID"] = sender.String()
 	info["cmd"] = cmd.Map()
 	// post notification: USER_ONLINE
 	NotificationPost("user_online", cpu, info)
-	return NewReceiptCommand("Login received", nil, 0, nil)
+	return cpu.RespondContent(NewReceiptCommand("Login received", nil, 0, nil))
 }
@@ -0,0 +1,64 @@
+/* license: https://mit-license.org
+ * ==============================================================================
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2021 Albert Moky
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ * ==============================================================================
+ */
+package cpu
+
+import (
+	. "github.com/dimchat/core-go/protocol"
+	. "github.com/dimchat/dkd-go/protocol"
+	. "github.com/dimchat/sdk-go/dimp"
+	. "github.com/dimchat/sdk-go/dimp/cpu"
+)
+
+/**
+ *  Receipt from client to station,
+ *  the stored message with the signature will be removed from inbox
+ */
+type DeliveryReceiptCommandProcessor struct {
+	BaseCommandProcessor
+}
+
+func NewDeliveryReceiptCommandProcessor(facebook IFacebook, messenger IMessenger) *DeliveryReceiptCommandProcessor {
+	cpu := new(DeliveryReceiptCommandProcessor)
+	cpu.Init(facebook, messenger)
+	return cpu
+}
+
+//-------- IContentProcessor
+
+func (cpu *DeliveryReceiptCommandProcessor) Process(content Content, rMsg ReliableMessage) []Content {
+	cmd, _ := content.(Command)
+	return cpu.Execute(cmd, rMsg)
+}
+
+func (cpu *DeliveryReceiptCommandProcessor) Execute(cmd Command, _ ReliableMessage) []Content {
+	signature, _ := cmd.Get("signature").(string)
+	messenger, ok := cpu.Messenger().(ISessionMessenger)
+	if ok && signature != "" {
+		messenger.ConfirmDelivery(signature)
+	}
+	// no need to response receipt command
+	return nil
+}
@@ -0,0 +1,71 @@
+/* license: https://mit-license.org
+ * ==============================================================================
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2021 Albert Moky
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ * ==============================================================================
+ */
+package dimp
+
+import (
+	. "github.com/dimchat/demo-go/sdk/common"
+	. "github.com/dimchat/demo-go/sdk/database"
+	"sync"
+)
+
+type IServerFacebook interface {
+	ICommonFacebook
+}
+
+type ServerFacebook struct {
+	CommonFacebook
+}
+
+func (facebook *ServerFacebook) Init() *ServerFacebook {
+	facebook.CommonFacebook.Init()
+	return facebook
+}
+
+//
+//  Singleton
+//
+var sharedFacebook *ServerFacebook
+var sharedFacebookOnce sync.Once
+
+func SharedFacebook() IServerFacebook {
+	sharedFacebookOnce.Do(func() {
+		sharedFacebook = new(ServerFacebook)
+		sharedFacebook.Init()
+		sharedFacebook.SetSource(sharedFacebook)
+		sharedFacebook.SetDB(SharedDatabase())
+		SharedAddressNameService().SetTable(SharedDatabase())
+	})
+	return sharedFacebook
+}
+
+/**
+ *  Replace the database for station before first use
+ */
+func ServerFacebookSetDatabase(db Database) {
+	SetSharedDatabase(db)
+	SharedFacebook().SetDB(db)
+	SharedAddressNameService().SetTable(db)
+}
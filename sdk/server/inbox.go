@@ -0,0 +1,215 @@
+/* license: https://mit-license.org
+ * ==============================================================================
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2021 Albert Moky
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ * ==============================================================================
+ */
+package dimp
+
+import (
+	"encoding/json"
+	. "github.com/dimchat/demo-go/sdk/utils"
+	. "github.com/dimchat/dkd-go/protocol"
+	. "github.com/dimchat/mkm-go/protocol"
+	"hash/fnv"
+	"os"
+	"sync"
+	"time"
+)
+
+/**
+ *  Offline Inbox
+ *  ~~~~~~~~~~~~~
+ *
+ *  Store messages for offline receivers, until delivered or receipts received
+ *
+ *  file path: '.dim/inbox/{ADDRESS}.js'
+ *
+ *  format: [{"time": 1234567890, "msg": {...}}, ...]  // oldest first
+ */
+type Inbox struct {
+
+	_root string
+	_quota int               // max messages for each receiver
+	_expires time.Duration   // drop messages older than it
+	_limitLock sync.RWMutex  // guards quota & expires
+
+	_locks [inboxLockStripes]sync.Mutex  // receiver locks
+}
+
+const (
+	DefaultInboxQuota = 1024
+	DefaultInboxExpires = 7 * 24 * time.Hour
+
+	inboxLockStripes = 64
+)
+
+func NewInbox(root string) *Inbox {
+	inbox := new(Inbox)
+	inbox.Init(root)
+	return inbox
+}
+
+func (inbox *Inbox) Init(root string) *Inbox {
+	inbox._root = root
+	inbox._quota = DefaultInboxQuota
+	inbox._expires = DefaultInboxExpires
+	return inbox
+}
+
+func (inbox *Inbox) SetLimits(quota int, expires time.Duration) {
+	inbox._limitLock.Lock()
+	defer inbox._limitLock.Unlock()
+	inbox._quota = quota
+	inbox._expires = expires
+}
+
+func (inbox *Inbox) limits() (int, time.Duration) {
+	inbox._limitLock.RLock()
+	defer inbox._limitLock.RUnlock()
+	return inbox._quota, inbox._expires
+}
+
+// messages for different receivers are stored in different files,
+// so only the same receiver needs to wait
+func (inbox *Inbox) lock(receiver ID) *sync.Mutex {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(receiver.Address().String()))
+	return &inbox._locks[hash.Sum32() % inboxLockStripes]
+}
+
+func (inbox *Inbox) path(receiver ID) string {
+	return PathJoin(inbox._root, receiver.Address().String() + ".js")
+}
+
+// load records, expired ones are dropped
+func (inbox *Inbox) load(receiver ID) []map[string]interface{} {
+	path := inbox.path(receiver)
+	var list []interface{}
+	if data := ReadBinaryFile(path); data != nil && json.Unmarshal(data, &list) != nil {
+		// move the broken file away, so it won't be overwritten by the next message
+		LogError("Inbox > corrupt file moved to: " + PathQuarantine(path))
+		list = nil
+	}
+	records := make([]map[string]interface{}, 0, len(list))
+	_, expires := inbox.limits()
+	expired := float64(time.Now().Add(-expires).Unix())
+	for _, item := range list {
+		record, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		when, _ := record["time"].(float64)
+		if when < expired {
+			continue
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func (inbox *Inbox) save(receiver ID, records []map[string]interface{}) bool {
+	path := inbox.path(receiver)
+	if len(records) == 0 {
+		return !PathIsExist(path) || PathRemove(path)
+	}
+	if err := os.MkdirAll(inbox._root, os.ModePerm); err != nil {
+		LogError("Inbox > failed to create directory: " + err.Error())
+		return false
+	}
+	return WriteJSONFile(path, records)
+}
+
+/**
+ *  Store message for offline receiver
+ *
+ * @param rMsg - message
+ * @return false when quota exceeded
+ */
+func (inbox *Inbox) Store(rMsg ReliableMessage) bool {
+	receiver := rMsg.Receiver()
+	lock := inbox.lock(receiver)
+	lock.Lock()
+	defer lock.Unlock()
+	records := inbox.load(receiver)
+	if quota, _ := inbox.limits(); len(records) >= quota {
+		LogWarning("Inbox > quota exceeded, drop message for: " + receiver.String())
+		return false
+	}
+	record := make(map[string]interface{})
+	record["time"] = time.Now().Unix()
+	record["msg"] = rMsg.Map()
+	return inbox.save(receiver, append(records, record))
+}
+
+/**
+ *  Get stored messages for receiver, oldest first
+ */
+func (inbox *Inbox) Messages(receiver ID) []ReliableMessage {
+	lock := inbox.lock(receiver)
+	lock.Lock()
+	defer lock.Unlock()
+	records := inbox.load(receiver)
+	messages := make([]ReliableMessage, 0, len(records))
+	for _, item := range records {
+		rMsg := ReliableMessageParse(item["msg"])
+		if rMsg != nil {
+			messages = append(messages, rMsg)
+		}
+	}
+	return messages
+}
+
+/**
+ *  Remove messages after delivered or receipt received
+ *
+ * @param receiver   - message receiver
+ * @param signatures - message signatures (base64)
+ * @return false when not found
+ */
+func (inbox *Inbox) Remove(receiver ID, signatures ...string) bool {
+	targets := make(map[string]bool, len(signatures))
+	for _, item := range signatures {
+		if item != "" {
+			targets[item] = true
+		}
+	}
+	if len(targets) == 0 {
+		return false
+	}
+	lock := inbox.lock(receiver)
+	lock.Lock()
+	defer lock.Unlock()
+	records := inbox.load(receiver)
+	results := make([]map[string]interface{}, 0, len(records))
+	for _, item := range records {
+		msg, _ := item["msg"].(map[string]interface{})
+		if signature, _ := msg["signature"].(string); targets[signature] {
+			continue
+		}
+		results = append(results, item)
+	}
+	if len(results) == len(records) {
+		return false
+	}
+	return inbox.save(receiver, results)
+}
@@ -0,0 +1,156 @@
+/* license: https://mit-license.org
+ * ==============================================================================
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2021 Albert Moky
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ * ==============================================================================
+ */
+package dimp
+
+import (
+	. "github.com/dimchat/demo-go/sdk/extensions"
+	. "github.com/dimchat/demo-go/sdk/utils"
+	. "github.com/dimchat/dkd-go/protocol"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func signaturesOf(messages []ReliableMessage) []string {
+	signatures := make([]string, 0, len(messages))
+	for _, msg := range messages {
+		signature, _ := msg.Get("signature").(string)
+		signatures = append(signatures, signature)
+	}
+	return signatures
+}
+
+func TestInboxStoreRemove(t *testing.T) {
+	root := PathJoin(t.TempDir(), "inbox")
+	inbox := NewInbox(root)
+	sender := GenerateUserInfo("sender", "").ID
+	receiver := GenerateUserInfo("receiver", "").ID
+	stored := make([]ReliableMessage, 0, 3)
+	for _, text := range []string{"Hello", "World", "!"} {
+		rMsg := newTestMessage(sender, receiver, text)
+		if inbox.Store(rMsg) == false {
+			t.Fatalf("failed to store message: %s", text)
+		}
+		stored = append(stored, rMsg)
+	}
+	signatures := signaturesOf(stored)
+	// oldest first
+	if got := signaturesOf(inbox.Messages(receiver)); len(got) != 3 || got[0] != signatures[0] || got[2] != signatures[2] {
+		t.Fatalf("stored messages error: %v", got)
+	}
+	if len(inbox.Messages(sender)) != 0 {
+		t.Fatal("messages stored for wrong receiver")
+	}
+	if inbox.Remove(receiver, signatures[1]) == false {
+		t.Fatal("failed to remove message")
+	}
+	if inbox.Remove(receiver, signatures[1]) {
+		t.Error("message removed twice")
+	}
+	// reload from file
+	reloaded := NewInbox(root)
+	if got := signaturesOf(reloaded.Messages(receiver)); len(got) != 2 || got[0] != signatures[0] || got[1] != signatures[2] {
+		t.Fatalf("messages not saved: %v", got)
+	}
+	if reloaded.Remove(receiver, signatures[0], signatures[2]) == false {
+		t.Fatal("failed to remove messages")
+	}
+	if PathIsExist(reloaded.path(receiver)) {
+		t.Error("empty inbox file not removed")
+	}
+}
+
+func TestInboxQuota(t *testing.T) {
+	inbox := NewInbox(PathJoin(t.TempDir(), "inbox"))
+	inbox.SetLimits(2, DefaultInboxExpires)
+	sender := GenerateUserInfo("sender", "").ID
+	receiver := GenerateUserInfo("receiver", "").ID
+	for index := 0; index < 2; index++ {
+		if inbox.Store(newTestMessage(sender, receiver, "Hello")) == false {
+			t.Fatalf("failed to store message: %d", index)
+		}
+	}
+	if inbox.Store(newTestMessage(sender, receiver, "Overflow")) {
+		t.Fatal("quota exceeded but message stored")
+	}
+	if len(inbox.Messages(receiver)) != 2 {
+		t.Fatalf("stored messages error: %d", len(inbox.Messages(receiver)))
+	}
+	// other receivers are not affected
+	if inbox.Store(newTestMessage(receiver, sender, "Hi")) == false {
+		t.Error("quota should be counted for each receiver")
+	}
+}
+
+func TestInboxExpires(t *testing.T) {
+	inbox := NewInbox(PathJoin(t.TempDir(), "inbox"))
+	inbox.SetLimits(DefaultInboxQuota, time.Hour)
+	sender := GenerateUserInfo("sender", "").ID
+	receiver := GenerateUserInfo("receiver", "").ID
+	old := make(map[string]interface{})
+	old["time"] = time.Now().Add(-2 * time.Hour).Unix()
+	old["msg"] = newTestMessage(sender, receiver, "Expired").Map()
+	if inbox.save(receiver, []map[string]interface{}{old}) == false {
+		t.Fatal("failed to save inbox")
+	}
+	rMsg := newTestMessage(sender, receiver, "Hello")
+	if inbox.Store(rMsg) == false {
+		t.Fatal("failed to store message")
+	}
+	messages := inbox.Messages(receiver)
+	if len(messages) != 1 || messages[0].Get("signature") != rMsg.Get("signature") {
+		t.Fatalf("expired message not dropped: %v", signaturesOf(messages))
+	}
+}
+
+func TestInboxCorruptFile(t *testing.T) {
+	inbox := NewInbox(PathJoin(t.TempDir(), "inbox"))
+	sender := GenerateUserInfo("sender", "").ID
+	receiver := GenerateUserInfo("receiver", "").ID
+	if inbox.Store(newTestMessage(sender, receiver, "Hello")) == false {
+		t.Fatal("failed to store message")
+	}
+	path := inbox.path(receiver)
+	// half-written file
+	broken := ReadTextFile(path)
+	broken = broken[:len(broken) / 2]
+	if WriteTextFile(path, broken) == false {
+		t.Fatal("failed to break inbox file")
+	}
+	if inbox.Store(newTestMessage(sender, receiver, "World")) == false {
+		t.Fatal("failed to store message after file corrupted")
+	}
+	if len(inbox.Messages(receiver)) != 1 {
+		t.Fatalf("stored messages error: %d", len(inbox.Messages(receiver)))
+	}
+	matches, _ := filepath.Glob(path + ".corrupt-*")
+	if len(matches) != 1 {
+		t.Fatalf("corrupt file not kept: %v", matches)
+	}
+	if ReadTextFile(matches[0]) != broken {
+		t.Error("corrupt file overwritten")
+	}
+}
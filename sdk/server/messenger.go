@@ -0,0 +1,157 @@
+/* license: https://mit-license.org
+ * ==============================================================================
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2021 Albert Moky
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ * ==============================================================================
+ */
+package dimp
+
+import (
+	. "github.com/dimchat/core-go/dimp"
+	. "github.com/dimchat/demo-go/sdk/common"
+	. "github.com/dimchat/demo-go/sdk/database"
+	. "github.com/dimchat/demo-go/sdk/server/cpu"
+	. "github.com/dimchat/dkd-go/protocol"
+	. "github.com/dimchat/mkm-go/protocol"
+	. "github.com/dimchat/sdk-go/dimp"
+	. "github.com/dimchat/sdk-go/dimp/cpu"
+	"time"
+)
+
+func createKeyCache() CipherKeyDelegate {
+	cache := new(KeyCache)
+	cache.Init()
+	cache.SetKeyTable(SharedDatabase())
+	return cache
+}
+func createProcessor(facebook IServerFacebook, messenger IServerMessenger) Processor {
+	// CPU creator
+	creator := new(ServerProcessorCreator)
+	creator.Init(facebook, messenger)
+	// CPU factory
+	factory := new(CPFactory)
+	factory.Init(facebook, messenger)
+	factory.SetCreator(creator)
+	// message processor
+	processor := new(ServerProcessor)
+	processor.Init(facebook, messenger)
+	processor.SetFactory(factory)
+	return processor
+}
+func createPacker(facebook IServerFacebook, messenger IServerMessenger) Packer {
+	packer := new(CommonPacker)
+	packer.Init(facebook, messenger)
+	return packer
+}
+
+type IServerMessenger interface {
+	ICommonMessenger
+	ISessionMessenger
+
+	Session() Session
+}
+
+/**
+ *  Server Messenger
+ *  ~~~~~~~~~~~~~~~~
+ *
+ *  One messenger for each client connection,
+ *  so the processors know which session the message comes from
+ */
+type ServerMessenger struct {
+	CommonMessenger
+
+	_station *Station
+	_session Session
+
+	_login ID  // logged in, stored messages not delivered yet
+}
+
+func NewServerMessenger(station *Station, session Session) *ServerMessenger {
+	messenger := new(ServerMessenger)
+	messenger.Init(SharedFacebook(), station, session)
+	return messenger
+}
+
+func (messenger *ServerMessenger) Init(facebook IServerFacebook, station *Station, session Session) *ServerMessenger {
+	if messenger.CommonMessenger.Init() != nil {
+		messenger._station = station
+		messenger._session = session
+		// initialize delegates for Transceiver
+		messenger.SetCipherKeyDelegate(createKeyCache())
+		messenger.SetEntityDelegate(facebook)
+		messenger.SetPacker(createPacker(facebook, messenger))
+		messenger.SetProcessor(createProcessor(facebook, messenger))
+	}
+	return messenger
+}
+
+func (messenger *ServerMessenger) Session() Session {
+	return messenger._session
+}
+
+//-------- ISessionMessenger
+
+func (messenger *ServerMessenger) SessionKey() string {
+	return messenger._session.Key()
+}
+
+func (messenger *ServerMessenger) SessionID() ID {
+	return messenger._session.ID()
+}
+
+func (messenger *ServerMessenger) LoginSession(identifier ID) bool {
+	if identifier == nil || identifier.IsUser() == false {
+		return false
+	}
+	messenger._station.loginSession(messenger._session, identifier)
+	// deliver stored messages after the handshake responded
+	messenger._login = identifier
+	return true
+}
+
+// pack content from the station to the receiver
+func (messenger *ServerMessenger) packContent(content Content, receiver ID) ReliableMessage {
+	env := EnvelopeCreate(messenger._station.ID(), receiver, time.Now())
+	iMsg := InstantMessageCreate(env, content)
+	sMsg := messenger.EncryptMessage(iMsg)
+	if sMsg == nil {
+		// public key not found?
+		return nil
+	}
+	return messenger.SignMessage(sMsg)
+}
+
+// take the user ID logged in while processing the last message
+func (messenger *ServerMessenger) popLogin() ID {
+	identifier := messenger._login
+	messenger._login = nil
+	return identifier
+}
+
+func (messenger *ServerMessenger) ConfirmDelivery(signature string) bool {
+	identifier := messenger._session.ID()
+	if identifier == nil {
+		return false
+	}
+	return messenger._station.Inbox().Remove(identifier, signature)
+}
@@ -26,11 +26,14 @@
 package dimp
 
 import (
+	. "github.com/dimchat/demo-go/sdk/utils"
 	. "github.com/dimchat/dkd-go/protocol"
 	. "github.com/dimchat/mkm-go/format"
 	. "github.com/dimchat/mkm-go/protocol"
 	. "github.com/dimchat/mkm-go/types"
 	. "github.com/dimchat/sdk-go/plugins/types"
+	"sync"
+	"time"
 )
 
 // format "(IP, Port)"
@@ -39,6 +42,9 @@ type SessionAddress string
 type SessionHandler interface {
 
 	PushMessage(msg ReliableMessage) bool
+
+	// close the connection, e.g. when the session expired
+	Close()
 }
 
 type Session interface {
@@ -57,8 +63,15 @@ type Session interface {
 	IsActive() bool
 	SetActive(active bool)
 
+	// last time received data from the client
+	LastActivity() time.Time
+	Touch()
+
 	// Push message when session active
 	PushMessage(msg ReliableMessage) bool
+
+	// Close the connection
+	Close()
 }
 
 func generateSessionKey() string {
@@ -73,6 +86,9 @@ type BaseSession struct {
 	_address SessionAddress
 	_active bool
 	_handler SessionHandler
+
+	_lastActivity time.Time
+	_lock sync.RWMutex  // guards ID, active & last activity
 }
 
 func NewSession(address SessionAddress, handler SessionHandler) Session {
@@ -87,15 +103,20 @@ func (session *BaseSession) Init(address SessionAddress, handler SessionHandler)
 	session._address = address
 	session._active = true
 	session._handler = handler
+	session._lastActivity = time.Now()
 	return session
 }
 
 //-------- Session
 
 func (session *BaseSession) ID() ID {
+	session._lock.RLock()
+	defer session._lock.RUnlock()
 	return session._identifier
 }
 func (session *BaseSession) SetID(identifier ID) {
+	session._lock.Lock()
+	defer session._lock.Unlock()
 	session._identifier = identifier
 }
 
@@ -108,42 +129,77 @@ func (session *BaseSession) ClientAddress() SessionAddress {
 }
 
 func (session *BaseSession) IsActive() bool {
+	session._lock.RLock()
+	defer session._lock.RUnlock()
 	return session._active
 }
 func (session *BaseSession) SetActive(active bool) {
+	session._lock.Lock()
+	defer session._lock.Unlock()
 	session._active = active
+	if active {
+		session._lastActivity = time.Now()
+	}
+}
+
+func (session *BaseSession) LastActivity() time.Time {
+	session._lock.RLock()
+	defer session._lock.RUnlock()
+	return session._lastActivity
+}
+func (session *BaseSession) Touch() {
+	session._lock.Lock()
+	defer session._lock.Unlock()
+	session._lastActivity = time.Now()
 }
 
 func (session *BaseSession) PushMessage(msg ReliableMessage) bool {
-	if session._active {
+	if session.IsActive() {
 		return session._handler.PushMessage(msg)
 	} else {
 		return false
 	}
 }
 
+func (session *BaseSession) Close() {
+	session.SetActive(false)
+	session._handler.Close()
+}
+
 /**
  *  Session Server
  *  ~~~~~~~~~~~~~~
+ *
+ *  Safe for concurrent use;
+ *  when a user's last session removed, notification "user_offline" will be posted
  */
 type SessionServer struct {
 
 	_clientAddresses map[ID][]SessionAddress
 	_sessions map[SessionAddress]Session
+	_lock sync.RWMutex
+
+	_reaper chan struct{}  // close it to stop the reaper
 }
 
+// default idle timeout for sessions
+const SessionIdleTimeout = 5 * time.Minute
+
 func (server *SessionServer) Init() *SessionServer {
 	server._clientAddresses = make(map[ID][]SessionAddress)
 	server._sessions = make(map[SessionAddress]Session)
+	server._reaper = nil
 	return server
 }
 
 // Session factory
 func (server *SessionServer) GetSession(address SessionAddress, handler SessionHandler) Session {
+	server._lock.Lock()
+	defer server._lock.Unlock()
 	session := server._sessions[address]
 	if session == nil && !ValueIsNil(handler) {
 		// create a new session and cache it
-		session := NewSession(address, handler)
+		session = NewSession(address, handler)
 		server._sessions[address] = session
 	}
 	return session
@@ -151,82 +207,111 @@ func (server *SessionServer) GetSession(address SessionAddress, handler SessionH
 
 func (server *SessionServer) insert(address SessionAddress, identifier ID) {
 	array := server._clientAddresses[identifier]
-	if array == nil {
-		array = make([]SessionAddress, 0, 1)
-	//} else {
-	//	for _, item := range array {
-	//		if item == address {
-	//			// already exists
-	//			return
-	//		}
-	//	}
+	for _, item := range array {
+		if item == address {
+			// already exists
+			return
+		}
 	}
 	server._clientAddresses[identifier] = append(array, address)
 }
 
-func (server *SessionServer) remove(address SessionAddress, identifier ID) {
+// return false when no session left for the user
+func (server *SessionServer) remove(address SessionAddress, identifier ID) bool {
 	array := server._clientAddresses[identifier]
 	if array == nil {
 		// not exists
-		return
+		return false
 	}
-	pos := len(array)
-	for pos > 0 {
-		pos--
-		if array[pos] == address {
-			array = append(array[:pos], array[pos+1:]...)
+	results := make([]SessionAddress, 0, len(array))
+	for _, item := range array {
+		if item != address {
+			results = append(results, item)
 		}
 	}
-	if len(array) == 0 {
+	if len(results) == 0 {
 		// all sessions removed
 		delete(server._clientAddresses, identifier)
+		return false
 	}
+	server._clientAddresses[identifier] = results
+	return true
 }
 
 // Insert a session with ID into memory cache
 func (server *SessionServer) UpdateSession(session Session, identifier ID) {
+	server._lock.Lock()
 	address := session.ClientAddress()
 	old := session.ID()
-	if old != nil {
+	online := true
+	if old != nil && !old.Equal(identifier) {
 		// 0. remove client_address from old ID
-		server.remove(address, old)
+		online = server.remove(address, old)
 	}
 	// 1. insert client_address for new ID
 	server.insert(address, identifier)
 	// 2. update session ID
 	session.SetID(identifier)
+	server._lock.Unlock()
+	if !online {
+		server.postOffline(old, address)
+	}
 }
 
 // Remove the session from memory cache
 func (server *SessionServer) RemoveSession(session Session) {
-	identifier := session.ID()
 	address := session.ClientAddress()
+	server._lock.Lock()
+	// read ID with lock, it may be changed by UpdateSession
+	identifier := session.ID()
+	if server._sessions[address] != session {
+		// already removed
+		server._lock.Unlock()
+		return
+	}
+	online := true
 	if identifier != nil {
 		// 1. remove client_address with ID
-		server.remove(address, identifier)
+		online = server.remove(address, identifier)
 	}
 	// 2. remove session with client_address
-	session.SetActive(false)
 	delete(server._sessions, address)
+	server._lock.Unlock()
+	session.SetActive(false)
+	if !online {
+		server.postOffline(identifier, address)
+	}
 }
 
-// Get all sessions of this user
-func (server *SessionServer) AllSessions(identifier ID) []Session {
+func (server *SessionServer) postOffline(identifier ID, address SessionAddress) {
+	info := make(map[string]interface{})
+	info["ID"] = identifier.String()
+	info["address"] = string(address)
+	// post notification: USER_OFFLINE
+	NotificationPost("user_offline", server, info)
+}
+
+func (server *SessionServer) allSessions(identifier ID) []Session {
 	results := make([]Session, 0, 1)
 	// 1. get all client_address with ID
 	array := server._clientAddresses[identifier]
-	if array != nil {
-		// 2. get session by each client_address
-		var session Session
-		for _, item := range array {
-			session = server._sessions[item]
-			if session != nil {
-				results = append(results, session)
-			}
+	// 2. get session by each client_address
+	var session Session
+	for _, item := range array {
+		session = server._sessions[item]
+		if session != nil {
+			results = append(results, session)
 		}
 	}
 	return results
 }
+
+// Get all sessions of this user
+func (server *SessionServer) AllSessions(identifier ID) []Session {
+	server._lock.RLock()
+	defer server._lock.RUnlock()
+	return server.allSessions(identifier)
+}
 func (server *SessionServer) ActiveSessions(identifier ID) []Session {
 	results := make([]Session, 0, 1)
 	// 1. get all sessions
@@ -245,7 +330,9 @@ func (server *SessionServer) ActiveSessions(identifier ID) []Session {
 //
 
 func (server *SessionServer) AllUsers() []ID {
-	users := make([]ID, 0, 8)
+	server._lock.RLock()
+	defer server._lock.RUnlock()
+	users := make([]ID, 0, len(server._clientAddresses))
 	for key := range server._clientAddresses {
 		users = append(users, key)
 	}
@@ -253,13 +340,7 @@ func (server *SessionServer) AllUsers() []ID {
 }
 
 func (server *SessionServer) IsActive(identifier ID) bool {
-	sessions := server.AllSessions(identifier)
-	for _, item := range sessions {
-		if item.IsActive() {
-			return true
-		}
-	}
-	return false
+	return len(server.ActiveSessions(identifier)) > 0
 }
 
 func (server *SessionServer) ActiveUsers() []ID {
@@ -272,3 +353,72 @@ func (server *SessionServer) ActiveUsers() []ID {
 	}
 	return users
 }
+
+//
+//  Reaper
+//
+
+/**
+ *  Remove & close sessions which have no activity for a while
+ *
+ * @param timeout - idle timeout
+ * @return expired sessions
+ */
+func (server *SessionServer) RemoveIdleSessions(timeout time.Duration) []Session {
+	server._lock.RLock()
+	expired := make([]Session, 0)
+	for _, session := range server._sessions {
+		if time.Since(session.LastActivity()) > timeout {
+			expired = append(expired, session)
+		}
+	}
+	server._lock.RUnlock()
+	for _, session := range expired {
+		LogInfo("SessionServer > session expired: " + string(session.ClientAddress()))
+		server.RemoveSession(session)
+		session.Close()
+	}
+	return expired
+}
+
+/**
+ *  Start a goroutine to remove idle sessions periodically
+ *
+ * @param timeout - idle timeout, e.g. SessionIdleTimeout
+ */
+func (server *SessionServer) StartReaper(timeout time.Duration) {
+	server._lock.Lock()
+	if server._reaper != nil {
+		// already started
+		server._lock.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	server._reaper = stop
+	server._lock.Unlock()
+	interval := timeout / 4
+	if interval < time.Second {
+		interval = time.Second
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				server.RemoveIdleSessions(timeout)
+			}
+		}
+	}()
+}
+
+func (server *SessionServer) StopReaper() {
+	server._lock.Lock()
+	defer server._lock.Unlock()
+	if server._reaper != nil {
+		close(server._reaper)
+		server._reaper = nil
+	}
+}
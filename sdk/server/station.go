@@ -0,0 +1,277 @@
+/* license: https://mit-license.org
+ * ==============================================================================
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2021 Albert Moky
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ * ==============================================================================
+ */
+package dimp
+
+import (
+	"fmt"
+	. "github.com/dimchat/demo-go/sdk/database"
+	. "github.com/dimchat/demo-go/sdk/utils"
+	"io"
+	. "github.com/dimchat/dkd-go/protocol"
+	. "github.com/dimchat/mkm-go/format"
+	. "github.com/dimchat/mkm-go/protocol"
+	. "github.com/dimchat/sdk-go/dimp/dkd"
+	"sync"
+)
+
+/**
+ *  Connection from client
+ *  ~~~~~~~~~~~~~~~~~~~~~~
+ *
+ *  Transport (TCP, WebSocket, ...) for a station
+ */
+type Connection interface {
+
+	// remote address "(IP, port)"
+	RemoteAddress() SessionAddress
+
+	// send one package to the client
+	Send(data []byte) bool
+
+	Close()
+}
+
+/**
+ *  Station
+ *  ~~~~~~~
+ *
+ *  Create session for each connection, process messages to the station,
+ *  and deliver other messages to the receivers' sessions,
+ *  or store them in the inbox when receivers offline
+ */
+type Station struct {
+
+	_identifier ID
+	_server *SessionServer
+	_inbox *Inbox
+
+	_lock sync.Mutex  // guards listeners
+
+	_listeners []io.Closer
+}
+
+func NewStation(identifier ID) *Station {
+	station := new(Station)
+	station.Init(identifier)
+	return station
+}
+
+func (station *Station) Init(identifier ID) *Station {
+	station._identifier = identifier
+	station._server = new(SessionServer).Init()
+	station._inbox = NewInbox(inboxRoot())
+	return station
+}
+
+// inbox in storage root directory
+func inboxRoot() string {
+	if storage, ok := SharedDatabase().(*Storage); ok {
+		return PathJoin(storage.Root(), "inbox")
+	}
+	return PathJoin(DefaultRoot, "inbox")
+}
+
+func (station *Station) ID() ID {
+	return station._identifier
+}
+
+func (station *Station) SessionServer() *SessionServer {
+	return station._server
+}
+
+func (station *Station) Inbox() *Inbox {
+	return station._inbox
+}
+
+func (station *Station) addListener(listener io.Closer) {
+	station._lock.Lock()
+	defer station._lock.Unlock()
+	station._listeners = append(station._listeners, listener)
+}
+
+// Close all listeners, and stop the session reaper
+func (station *Station) Close() {
+	station._server.StopReaper()
+	station._lock.Lock()
+	listeners := station._listeners
+	station._listeners = nil
+	station._lock.Unlock()
+	for _, item := range listeners {
+		_ = item.Close()
+	}
+}
+
+// bind session with user ID
+func (station *Station) loginSession(session Session, identifier ID) {
+	station._server.UpdateSession(session, identifier)
+	session.SetActive(true)
+}
+
+// deliver stored messages to the session,
+// the sent ones are removed, so they won't be delivered again on next handshake
+func (station *Station) deliverInbox(session Session, identifier ID) {
+	delivered := make([]string, 0)
+	for _, msg := range station._inbox.Messages(identifier) {
+		if session.PushMessage(msg) == false {
+			// try again next time
+			break
+		}
+		signature, _ := msg.Get("signature").(string)
+		delivered = append(delivered, signature)
+	}
+	station._inbox.Remove(identifier, delivered...)
+}
+
+/**
+ *  Create session for new connection
+ *
+ * @param conn - client connection
+ * @return handler for received packages
+ */
+func (station *Station) Connect(conn Connection) *ConnectionHandler {
+	handler := new(ConnectionHandler)
+	handler._station = station
+	handler._conn = conn
+	handler._session = station._server.GetSession(conn.RemoteAddress(), handler)
+	handler._messenger = NewServerMessenger(station, handler._session)
+	LogInfo(fmt.Sprintf("Station > connected: %s", conn.RemoteAddress()))
+	return handler
+}
+
+// remove session for the closed connection
+func (station *Station) disconnect(handler *ConnectionHandler) {
+	station._server.RemoveSession(handler._session)
+	LogInfo(fmt.Sprintf("Station > disconnected: %s", handler._conn.RemoteAddress()))
+}
+
+// process message to the station, or deliver it to the receiver
+func (station *Station) dispatch(rMsg ReliableMessage, handler *ConnectionHandler) {
+	receiver := rMsg.Receiver()
+	if station.isStation(receiver) {
+		responses := handler._messenger.ProcessReliableMessage(rMsg)
+		for _, res := range responses {
+			handler.PushMessage(res)
+		}
+		// stored messages go after the handshake success,
+		// so the client knows it's logged in before receiving them
+		if identifier := handler._messenger.popLogin(); identifier != nil {
+			station.deliverInbox(handler._session, identifier)
+		}
+		return
+	}
+	// check sender, only delivers messages from logged-in users
+	sender := rMsg.Sender()
+	current := handler._session.ID()
+	if current == nil || current.Equal(sender) == false {
+		LogWarning("Station > handshake first: " + sender.String())
+		return
+	}
+	if receiver.IsBroadcast() || receiver.IsGroup() {
+		// TODO: split group message for members
+		LogWarning("Station > group/broadcast message not supported: " + receiver.String())
+		return
+	}
+	delivered := false
+	for _, session := range station._server.ActiveSessions(receiver) {
+		if session.PushMessage(rMsg) {
+			delivered = true
+		}
+	}
+	if !delivered {
+		LogInfo("Station > receiver offline, store message for: " + receiver.String())
+		var text string
+		if station._inbox.Store(rMsg) {
+			text = "Message stored for offline receiver"
+		} else {
+			text = "Failed to store message for offline receiver"
+		}
+		// let the sender know whether the message is kept
+		receipt := NewReceiptCommand(text, rMsg.Envelope(), 0, nil)
+		receipt.Set("signature", rMsg.Get("signature"))
+		if res := handler._messenger.packContent(receipt, sender); res != nil {
+			handler.PushMessage(res)
+		}
+	}
+}
+
+func (station *Station) isStation(receiver ID) bool {
+	if receiver.Equal(station._identifier) {
+		return true
+	}
+	// 'station@anywhere'
+	return receiver.Type() == STATION && receiver.IsBroadcast()
+}
+
+/**
+ *  Connection Handler
+ *  ~~~~~~~~~~~~~~~~~~
+ *
+ *  Bridge between client connection and its session
+ */
+type ConnectionHandler struct {
+	SessionHandler
+
+	_station *Station
+	_conn Connection
+	_session Session
+	_messenger *ServerMessenger
+}
+
+func (handler *ConnectionHandler) Session() Session {
+	return handler._session
+}
+
+//-------- SessionHandler
+
+func (handler *ConnectionHandler) PushMessage(msg ReliableMessage) bool {
+	json := JSONEncodeMap(msg.Map())
+	return handler._conn.Send(UTF8Encode(json))
+}
+
+func (handler *ConnectionHandler) Close() {
+	handler._conn.Close()
+}
+
+// Received a package with a reliable message in JSON format
+func (handler *ConnectionHandler) Received(data []byte) {
+	handler._session.Touch()
+	defer func() {
+		if r := recover(); r != nil {
+			LogError(fmt.Sprintf("Station > failed to process message: %v", r))
+		}
+	}()
+	rMsg := ReliableMessageParse(JSONDecodeMap(UTF8Decode(data)))
+	if rMsg == nil {
+		LogError("Station > message error: " + UTF8Decode(data))
+		return
+	}
+	handler._station.dispatch(rMsg, handler)
+}
+
+// Connection closed
+func (handler *ConnectionHandler) Closed() {
+	handler._station.disconnect(handler)
+}
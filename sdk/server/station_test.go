@@ -0,0 +1,320 @@
+/* license: https://mit-license.org
+ * ==============================================================================
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2021 Albert Moky
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ * ==============================================================================
+ */
+package dimp
+
+import (
+	"fmt"
+	. "github.com/dimchat/demo-go/sdk/common/db"
+	. "github.com/dimchat/demo-go/sdk/database"
+	. "github.com/dimchat/demo-go/sdk/extensions"
+	. "github.com/dimchat/demo-go/sdk/utils"
+	. "github.com/dimchat/dkd-go/protocol"
+	. "github.com/dimchat/mkm-go/crypto"
+	. "github.com/dimchat/mkm-go/format"
+	. "github.com/dimchat/mkm-go/protocol"
+	. "github.com/dimchat/sdk-go/dimp/protocol"
+	. "github.com/dimchat/sdk-go/plugins/types"
+	"sync"
+	"testing"
+	"time"
+)
+
+/**
+ *  Fake connection, keeps the packages sent to the client
+ */
+type testConnection struct {
+	Connection
+
+	_address SessionAddress
+	_packages [][]byte
+	_closed bool
+	_lock sync.Mutex
+}
+
+func newTestConnection(address string) *testConnection {
+	conn := new(testConnection)
+	conn._address = SessionAddress(address)
+	return conn
+}
+
+func (conn *testConnection) RemoteAddress() SessionAddress {
+	return conn._address
+}
+
+func (conn *testConnection) Send(data []byte) bool {
+	conn._lock.Lock()
+	defer conn._lock.Unlock()
+	if conn._closed {
+		return false
+	}
+	conn._packages = append(conn._packages, data)
+	return true
+}
+
+func (conn *testConnection) Close() {
+	conn._lock.Lock()
+	defer conn._lock.Unlock()
+	conn._closed = true
+}
+
+// messages sent to the client
+func (conn *testConnection) messages() []ReliableMessage {
+	conn._lock.Lock()
+	defer conn._lock.Unlock()
+	messages := make([]ReliableMessage, 0, len(conn._packages))
+	for _, data := range conn._packages {
+		rMsg := ReliableMessageParse(JSONDecodeMap(UTF8Decode(data)))
+		if rMsg != nil {
+			messages = append(messages, rMsg)
+		}
+	}
+	return messages
+}
+
+/**
+ *  Observer for notifications
+ */
+type testObserver struct {
+	_notifications []Notification
+}
+
+func (observer *testObserver) OnNotificationReceived(notify Notification) {
+	observer._notifications = append(observer._notifications, notify)
+}
+
+// save meta, visa & private keys into the shared database
+func saveTestUser(t *testing.T, info *UserInfo) {
+	db := SharedDatabase()
+	if db.SaveMeta(info.Meta, info.ID) == false ||
+		db.SavePrivateKey(info.ID, info.IdentityKey.(PrivateKey), META_KEY, true, false) == false ||
+		db.SavePrivateKey(info.ID, info.CommunicationKey.(PrivateKey), VISA_KEY, true, true) == false ||
+		db.SaveDocument(info.Visa) == false {
+		t.Fatalf("failed to save user: %s", info.ID)
+	}
+}
+
+// station with memory database & temporary inbox
+func newTestStation(t *testing.T) *Station {
+	ServerFacebookSetDatabase(NewMemoryStorage())
+	info := GenerateStationInfo("test", "Test Station", "", "127.0.0.1", 9394)
+	saveTestUser(t, info)
+	station := NewStation(info.ID)
+	station._inbox = NewInbox(PathJoin(t.TempDir(), "inbox"))
+	return station
+}
+
+// client connected to the station, logged in with a new user
+func newTestClient(t *testing.T, station *Station, name string, port int) (*ConnectionHandler, *testConnection, ID) {
+	conn := newTestConnection(fmt.Sprintf("(127.0.0.1, %d)", port))
+	handler := station.Connect(conn)
+	info := GenerateUserInfo(name, "")
+	saveTestUser(t, info)
+	station.loginSession(handler._session, info.ID)
+	return handler, conn, info.ID
+}
+
+// message from sender to receiver, the body is not encrypted
+func newTestMessage(sender ID, receiver ID, text string) ReliableMessage {
+	info := make(map[string]interface{})
+	info["sender"] = sender.String()
+	info["receiver"] = receiver.String()
+	info["time"] = time.Now().Unix()
+	info["data"] = Base64Encode([]byte(text))
+	info["signature"] = Base64Encode(RandomBytes(64))
+	return ReliableMessageParse(info)
+}
+
+func TestLoginCommandNotification(t *testing.T) {
+	station := newTestStation(t)
+	handler := station.Connect(newTestConnection("(127.0.0.1, 10001)"))
+	user := GenerateUserInfo("user", "").ID
+
+	observer := new(testObserver)
+	NotificationAddObserver(observer, "user_online")
+	defer NotificationRemoveObserver(observer, "user_online")
+
+	info := make(map[string]interface{})
+	info["type"] = COMMAND
+	info["command"] = LOGIN
+	info["ID"] = user.String()
+	info["time"] = time.Now().Unix()
+	cmd := ContentParse(info)
+	if cmd == nil {
+		t.Fatal("failed to create login command")
+	}
+	rMsg := newTestMessage(user, station.ID(), "login")
+	responses := handler._messenger.Processor().ProcessContent(cmd, rMsg)
+	if len(responses) != 1 {
+		t.Fatalf("login not responded: %v", responses)
+	}
+	if len(observer._notifications) != 1 {
+		t.Fatalf("user_online not posted: %v", observer._notifications)
+	}
+	if observer._notifications[0].Info()["ID"] != user.String() {
+		t.Fatalf("user_online info error: %v", observer._notifications[0].Info())
+	}
+}
+
+func newHandshakeCommand(session string) Content {
+	info := make(map[string]interface{})
+	info["type"] = COMMAND
+	info["command"] = HANDSHAKE
+	info["message"] = "Hello world!"
+	if session != "" {
+		info["session"] = session
+	}
+	return ContentParse(info)
+}
+
+func TestHandshakeDeliverInbox(t *testing.T) {
+	station := newTestStation(t)
+	sender := GenerateUserInfo("sender", "").ID
+	user := GenerateUserInfo("user", "").ID
+	stored := []ReliableMessage{
+		newTestMessage(sender, user, "Hello"),
+		newTestMessage(sender, user, "World"),
+	}
+	for _, rMsg := range stored {
+		if station.Inbox().Store(rMsg) == false {
+			t.Fatal("failed to store message")
+		}
+	}
+	conn := newTestConnection("(127.0.0.1, 10002)")
+	handler := station.Connect(conn)
+	processor := handler._messenger.Processor()
+
+	// 1. without session key
+	rMsg := newTestMessage(user, station.ID(), "handshake")
+	if responses := processor.ProcessContent(newHandshakeCommand(""), rMsg); len(responses) != 1 {
+		t.Fatalf("handshake not responded: %v", responses)
+	}
+	if handler._messenger.popLogin() != nil || handler._session.IsActive() {
+		t.Fatal("logged in without session key")
+	}
+
+	// 2. with session key
+	if responses := processor.ProcessContent(newHandshakeCommand(handler._session.Key()), rMsg); len(responses) != 1 {
+		t.Fatalf("handshake not responded: %v", responses)
+	}
+	identifier := handler._messenger.popLogin()
+	if identifier == nil || identifier.Equal(user) == false {
+		t.Fatalf("login error: %v", identifier)
+	}
+	if handler._session.IsActive() == false || user.Equal(handler._session.ID()) == false {
+		t.Fatal("session not bound to user")
+	}
+	if station._server.IsActive(user) == false {
+		t.Fatal("user not active")
+	}
+
+	// 3. deliver stored messages, in order
+	station.deliverInbox(handler._session, identifier)
+	if got := signaturesOf(conn.messages()); len(got) != 2 ||
+		got[0] != stored[0].Get("signature") || got[1] != stored[1].Get("signature") {
+		t.Fatalf("stored messages not delivered: %v", got)
+	}
+	if len(station.Inbox().Messages(user)) != 0 {
+		t.Fatal("delivered messages not removed from inbox")
+	}
+	// no more messages on next handshake
+	station.deliverInbox(handler._session, identifier)
+	if len(conn.messages()) != 2 {
+		t.Fatalf("messages delivered again: %d", len(conn.messages()))
+	}
+}
+
+func TestDispatchMessage(t *testing.T) {
+	station := newTestStation(t)
+	handler, _, sender := newTestClient(t, station, "sender", 10003)
+	receiver := GenerateUserInfo("receiver", "").ID
+
+	// 1. receiver offline, store it
+	stored := newTestMessage(sender, receiver, "Hello")
+	station.dispatch(stored, handler)
+	messages := station.Inbox().Messages(receiver)
+	if len(messages) != 1 || messages[0].Get("signature") != stored.Get("signature") {
+		t.Fatalf("message not stored: %v", signaturesOf(messages))
+	}
+
+	// 2. receiver online, deliver it
+	online, conn, _ := newTestClient(t, station, "receiver", 10004)
+	station.loginSession(online._session, receiver)
+	_, otherConn, _ := newTestClient(t, station, "other", 10005)
+	rMsg := newTestMessage(sender, receiver, "World")
+	station.dispatch(rMsg, handler)
+	if len(station.Inbox().Messages(receiver)) != 1 {
+		t.Fatal("message stored for online receiver")
+	}
+	if got := conn.messages(); len(got) != 1 || got[0].Get("signature") != rMsg.Get("signature") {
+		t.Fatalf("message not delivered: %v", signaturesOf(got))
+	}
+	if len(otherConn.messages()) != 0 {
+		t.Error("message delivered to wrong user")
+	}
+
+	// 3. receipt from receiver
+	if online._messenger.ConfirmDelivery(stored.Get("signature").(string)) == false {
+		t.Fatal("failed to confirm delivery")
+	}
+	if len(station.Inbox().Messages(receiver)) != 0 {
+		t.Error("message not removed after receipt")
+	}
+
+	// 4. sender not logged in
+	stranger := station.Connect(newTestConnection("(127.0.0.1, 10006)"))
+	station.dispatch(newTestMessage(sender, receiver, "Hi"), stranger)
+	if len(station.Inbox().Messages(receiver)) != 0 {
+		t.Error("message from stranger stored")
+	}
+}
+
+func TestSessionIdleExpiry(t *testing.T) {
+	station := newTestStation(t)
+	handler, conn, user := newTestClient(t, station, "user", 10007)
+	observer := new(testObserver)
+	NotificationAddObserver(observer, "user_offline")
+	defer NotificationRemoveObserver(observer, "user_offline")
+
+	if expired := station._server.RemoveIdleSessions(time.Hour); len(expired) != 0 {
+		t.Fatalf("active session expired: %v", expired)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if expired := station._server.RemoveIdleSessions(time.Millisecond); len(expired) != 1 {
+		t.Fatalf("idle session not expired: %v", expired)
+	}
+	if handler._session.IsActive() || station._server.IsActive(user) {
+		t.Error("expired session still active")
+	}
+	conn._lock.Lock()
+	closed := conn._closed
+	conn._lock.Unlock()
+	if !closed {
+		t.Error("connection not closed")
+	}
+	if len(observer._notifications) != 1 || observer._notifications[0].Info()["ID"] != user.String() {
+		t.Fatalf("user_offline not posted: %v", observer._notifications)
+	}
+}
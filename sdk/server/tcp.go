@@ -0,0 +1,146 @@
+/* license: https://mit-license.org
+ * ==============================================================================
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2021 Albert Moky
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ * ==============================================================================
+ */
+package dimp
+
+import (
+	"bufio"
+	"fmt"
+	. "github.com/dimchat/demo-go/sdk/utils"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/**
+ *  TCP Connection
+ *  ~~~~~~~~~~~~~~
+ *
+ *  Each package is a JSON string of reliable message, ends with '\n'
+ */
+type TCPConnection struct {
+	Connection
+
+	_conn net.Conn
+	_address SessionAddress
+	_lock sync.Mutex  // guards writing
+}
+
+// max size for one package
+const MaxPackageSize = 1024 * 1024
+
+// max time for writing one package, so a peer stopped reading won't block the sender
+var ConnectionWriteTimeout = 10 * time.Second
+
+func NewTCPConnection(conn net.Conn) *TCPConnection {
+	connection := new(TCPConnection)
+	connection.Init(conn)
+	return connection
+}
+
+func (connection *TCPConnection) Init(conn net.Conn) *TCPConnection {
+	connection._conn = conn
+	connection._address = ParseSessionAddress(conn.RemoteAddr().String())
+	return connection
+}
+
+func (connection *TCPConnection) RemoteAddress() SessionAddress {
+	return connection._address
+}
+
+func (connection *TCPConnection) Send(data []byte) bool {
+	pack := make([]byte, 0, len(data)+1)
+	pack = append(pack, data...)
+	pack = append(pack, '\n')
+	connection._lock.Lock()
+	defer connection._lock.Unlock()
+	if err := connection._conn.SetWriteDeadline(time.Now().Add(ConnectionWriteTimeout)); err != nil {
+		return false
+	}
+	_, err := connection._conn.Write(pack)
+	return err == nil
+}
+
+func (connection *TCPConnection) Close() {
+	_ = connection._conn.Close()
+}
+
+// read packages until the connection closed
+func (connection *TCPConnection) run(handler *ConnectionHandler) {
+	defer func() {
+		connection.Close()
+		handler.Closed()
+	}()
+	scanner := bufio.NewScanner(connection._conn)
+	scanner.Buffer(make([]byte, 0, 4096), MaxPackageSize)
+	for scanner.Scan() {
+		data := scanner.Bytes()
+		if len(data) == 0 {
+			// heartbeat
+			handler.Session().Touch()
+			continue
+		}
+		pack := make([]byte, len(data))
+		copy(pack, data)
+		handler.Received(pack)
+	}
+	if err := scanner.Err(); err != nil {
+		LogError(fmt.Sprintf("Station > read error: %s, %v", connection._address, err))
+	}
+}
+
+// format "(IP, Port)"
+func ParseSessionAddress(address string) SessionAddress {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return SessionAddress(address)
+	}
+	num, _ := strconv.Atoi(port)
+	return SessionAddress(fmt.Sprintf("(%s, %d)", host, num))
+}
+
+/**
+ *  Listen TCP connections for the station, blocked until the listener closed
+ *
+ * @param address - "host:port"
+ * @return error when listen failed or the listener closed
+ */
+func (station *Station) ListenTCP(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	LogInfo("Station > listening TCP: " + listener.Addr().String())
+	station.addListener(listener)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		connection := NewTCPConnection(conn)
+		go connection.run(station.Connect(connection))
+	}
+}
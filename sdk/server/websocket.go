@@ -0,0 +1,349 @@
+/* license: https://mit-license.org
+ * ==============================================================================
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2021 Albert Moky
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ * ==============================================================================
+ */
+package dimp
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	. "github.com/dimchat/demo-go/sdk/utils"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+/**
+ *  WebSocket Connection
+ *  ~~~~~~~~~~~~~~~~~~~~
+ *
+ *  Each text frame is a JSON string of reliable message (RFC 6455),
+ *  the station sends ping every interval, the logged-in session is marked
+ *  active by any frame (including ping & pong) from the client, inactive
+ *  when no frame received for 2 intervals, and the connection will be
+ *  closed when no frame received for the idle timeout
+ */
+type WebSocketConnection struct {
+	Connection
+
+	_conn net.Conn
+	_reader *bufio.Reader
+	_address SessionAddress
+	_lock sync.Mutex  // guards writing
+
+	_closed chan struct{}
+	_closeOnce sync.Once
+}
+
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xA
+)
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var (
+	WebSocketPingInterval = 30 * time.Second
+	WebSocketIdleTimeout  = 90 * time.Second  // close connection after no frame received
+	WebSocketCloseTimeout = 5 * time.Second   // max time for sending close frame
+)
+
+func NewWebSocketConnection(conn net.Conn, reader *bufio.Reader, remote string) *WebSocketConnection {
+	connection := new(WebSocketConnection)
+	connection.Init(conn, reader, remote)
+	return connection
+}
+
+func (connection *WebSocketConnection) Init(conn net.Conn, reader *bufio.Reader, remote string) *WebSocketConnection {
+	connection._conn = conn
+	connection._reader = reader
+	connection._address = ParseSessionAddress(remote)
+	connection._closed = make(chan struct{})
+	return connection
+}
+
+func (connection *WebSocketConnection) RemoteAddress() SessionAddress {
+	return connection._address
+}
+
+func (connection *WebSocketConnection) Send(data []byte) bool {
+	return connection.writeFrame(wsText, data, ConnectionWriteTimeout) == nil
+}
+
+func (connection *WebSocketConnection) Close() {
+	connection._closeOnce.Do(func() {
+		// don't block on a peer that stopped reading,
+		// the deadline also releases the writer holding the lock
+		_ = connection._conn.SetWriteDeadline(time.Now().Add(WebSocketCloseTimeout))
+		_ = connection.writeFrame(wsClose, nil, WebSocketCloseTimeout)
+		close(connection._closed)
+		_ = connection._conn.Close()
+	})
+}
+
+func (connection *WebSocketConnection) writeFrame(opcode byte, payload []byte, timeout time.Duration) error {
+	size := len(payload)
+	frame := make([]byte, 0, size+10)
+	frame = append(frame, 0x80|opcode)
+	if size < 126 {
+		frame = append(frame, byte(size))
+	} else if size <= 0xFFFF {
+		frame = append(frame, 126, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(size))
+	} else {
+		frame = append(frame, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(size))
+	}
+	frame = append(frame, payload...)
+	connection._lock.Lock()
+	defer connection._lock.Unlock()
+	if err := connection._conn.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	_, err := connection._conn.Write(frame)
+	return err
+}
+
+// read one frame, client frames must be masked
+func (connection *WebSocketConnection) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	reader := connection._reader
+	header := make([]byte, 2)
+	if _, err = io.ReadFull(reader, header); err != nil {
+		return
+	}
+	fin = header[0]&0x80 != 0
+	opcode = header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+	size := uint64(header[1] & 0x7F)
+	if size == 126 {
+		ext := make([]byte, 2)
+		if _, err = io.ReadFull(reader, ext); err != nil {
+			return
+		}
+		size = uint64(binary.BigEndian.Uint16(ext))
+	} else if size == 127 {
+		ext := make([]byte, 8)
+		if _, err = io.ReadFull(reader, ext); err != nil {
+			return
+		}
+		size = binary.BigEndian.Uint64(ext)
+	}
+	if !masked {
+		err = errors.New("frame not masked")
+		return
+	} else if size > MaxPackageSize {
+		err = fmt.Errorf("frame too large: %d", size)
+		return
+	} else if opcode >= wsClose && (!fin || size > 125) {
+		err = errors.New("control frame error")
+		return
+	}
+	mask := make([]byte, 4)
+	if _, err = io.ReadFull(reader, mask); err != nil {
+		return
+	}
+	payload = make([]byte, size)
+	if _, err = io.ReadFull(reader, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return
+}
+
+// read frames until the connection closed
+func (connection *WebSocketConnection) run(handler *ConnectionHandler) {
+	session := handler.Session()
+	lastTime := time.Now()
+	var timeLock sync.Mutex
+	touch := func() {
+		timeLock.Lock()
+		lastTime = time.Now()
+		timeLock.Unlock()
+		if session.ID() == nil {
+			// not logged in yet, it will be activated by handshake
+			session.Touch()
+		} else {
+			session.SetActive(true)
+		}
+	}
+	idle := func() time.Duration {
+		timeLock.Lock()
+		defer timeLock.Unlock()
+		return time.Since(lastTime)
+	}
+	go connection.keepAlive(session, idle)
+	defer func() {
+		connection.Close()
+		handler.Closed()
+	}()
+	var message []byte
+	var messageType byte
+	for {
+		fin, opcode, payload, err := connection.readFrame()
+		if err != nil {
+			if err != io.EOF {
+				LogError(fmt.Sprintf("Station > websocket error: %s, %v", connection._address, err))
+			}
+			return
+		}
+		touch()
+		switch opcode {
+		case wsPing:
+			_ = connection.writeFrame(wsPong, payload, ConnectionWriteTimeout)
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			return
+		case wsText, wsBinary:
+			messageType = opcode
+			message = payload
+		case wsContinuation:
+			if message == nil || len(message)+len(payload) > MaxPackageSize {
+				LogError("Station > websocket continuation error: " + string(connection._address))
+				return
+			}
+			message = append(message, payload...)
+		default:
+			LogError(fmt.Sprintf("Station > websocket opcode error: %d", opcode))
+			return
+		}
+		if !fin {
+			// waiting for continuation
+			continue
+		}
+		if messageType == wsText && len(message) > 0 {
+			handler.Received(message)
+		} else if messageType == wsBinary {
+			LogWarning("Station > websocket binary frame not supported: " + string(connection._address))
+		}
+		message = nil
+	}
+}
+
+// send ping every interval, update session state by the idle time
+func (connection *WebSocketConnection) keepAlive(session Session, idle func() time.Duration) {
+	ticker := time.NewTicker(WebSocketPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-connection._closed:
+			return
+		case <-ticker.C:
+		}
+		elapsed := idle()
+		if elapsed > WebSocketIdleTimeout {
+			LogWarning("Station > websocket timeout: " + string(connection._address))
+			connection.Close()
+			return
+		} else if elapsed > 2*WebSocketPingInterval {
+			// messages will be stored in inbox until the client responds
+			session.SetActive(false)
+		}
+		if connection.writeFrame(wsPing, nil, ConnectionWriteTimeout) != nil {
+			connection.Close()
+			return
+		}
+	}
+}
+
+func headerContains(header http.Header, key string, token string) bool {
+	for _, value := range header.Values(key) {
+		for _, item := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(item), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func websocketAccept(key string) string {
+	digest := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(digest[:])
+}
+
+// upgrade HTTP request to WebSocket connection
+func (station *Station) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet || key == "" ||
+		!headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "WebSocket only", http.StatusBadRequest)
+		return
+	} else if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "WebSocket version not supported", http.StatusUpgradeRequired)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSocket not supported", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		LogError(fmt.Sprintf("Station > websocket upgrade error: %v", err))
+		return
+	}
+	_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + websocketAccept(key) + "\r\n\r\n")
+	if rw.Flush() != nil {
+		_ = conn.Close()
+		return
+	}
+	connection := NewWebSocketConnection(conn, rw.Reader, r.RemoteAddr)
+	connection.run(station.Connect(connection))
+}
+
+/**
+ *  Listen WebSocket connections for the station, blocked until the listener closed
+ *
+ * @param address - "host:port"
+ * @param path    - URL path, e.g. "/"
+ * @return error when listen failed or the listener closed
+ */
+func (station *Station) ListenWebSocket(address string, path string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, station.serveWebSocket)
+	server := &http.Server{Addr: address, Handler: mux}
+	station.addListener(server)
+	LogInfo("Station > listening WebSocket: " + address + path)
+	return server.ListenAndServe()
+}
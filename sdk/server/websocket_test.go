@@ -0,0 +1,148 @@
+/* license: https://mit-license.org
+ * ==============================================================================
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2021 Albert Moky
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ * ==============================================================================
+ */
+package dimp
+
+import (
+	"bufio"
+	"encoding/binary"
+	. "github.com/dimchat/demo-go/sdk/extensions"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// write a masked frame from client
+func writeClientFrame(conn net.Conn, opcode byte, payload []byte) error {
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	frame := []byte{0x80 | opcode, 0x80 | byte(len(payload))}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	_, err := conn.Write(frame)
+	return err
+}
+
+// read an unmasked frame from station
+func readServerFrame(reader *bufio.Reader) (byte, []byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return 0, nil, err
+	}
+	size := int(header[1] & 0x7F)
+	if size == 126 {
+		ext := make([]byte, 2)
+		if _, err := io.ReadFull(reader, ext); err != nil {
+			return 0, nil, err
+		}
+		size = int(binary.BigEndian.Uint16(ext))
+	}
+	payload := make([]byte, size)
+	_, err := io.ReadFull(reader, payload)
+	return header[0] & 0x0F, payload, err
+}
+
+func TestWebSocketPingActivatesSession(t *testing.T) {
+	station := newTestStation(t)
+	server, client := net.Pipe()
+	defer client.Close()
+	connection := NewWebSocketConnection(server, bufio.NewReader(server), "127.0.0.1:10011")
+	handler := station.Connect(connection)
+	done := make(chan struct{})
+	go func() {
+		connection.run(handler)
+		close(done)
+	}()
+	reader := bufio.NewReader(client)
+	session := handler.Session()
+
+	// 1. not logged in, ping won't activate the session
+	if err := writeClientFrame(client, wsPing, []byte("1")); err != nil {
+		t.Fatalf("failed to send ping: %v", err)
+	}
+	if opcode, payload, err := readServerFrame(reader); err != nil || opcode != wsPong || string(payload) != "1" {
+		t.Fatalf("pong error: %d, %s, %v", opcode, payload, err)
+	}
+	if session.IsActive() {
+		t.Fatal("session activated before handshake")
+	}
+
+	// 2. logged in, but inactive for a while
+	user := GenerateUserInfo("user", "").ID
+	station.loginSession(session, user)
+	session.SetActive(false)
+	if err := writeClientFrame(client, wsPong, nil); err != nil {
+		t.Fatalf("failed to send pong: %v", err)
+	}
+	// ping again to wait for the pong processed
+	if err := writeClientFrame(client, wsPing, []byte("2")); err != nil {
+		t.Fatalf("failed to send ping: %v", err)
+	}
+	if _, _, err := readServerFrame(reader); err != nil {
+		t.Fatalf("pong error: %v", err)
+	}
+	if session.IsActive() == false || station._server.IsActive(user) == false {
+		t.Fatal("session not activated by pong")
+	}
+
+	// 3. close
+	if err := writeClientFrame(client, wsClose, nil); err != nil {
+		t.Fatalf("failed to send close: %v", err)
+	}
+	go func() {
+		// drain the close frame
+		_, _, _ = readServerFrame(reader)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection not closed")
+	}
+	if session.IsActive() {
+		t.Error("session still active after closed")
+	}
+}
+
+func TestWebSocketSendTimeout(t *testing.T) {
+	timeout := ConnectionWriteTimeout
+	ConnectionWriteTimeout = 100 * time.Millisecond
+	defer func() {
+		ConnectionWriteTimeout = timeout
+	}()
+	server, client := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	connection := NewWebSocketConnection(server, bufio.NewReader(server), "127.0.0.1:10012")
+	// the client never reads
+	start := time.Now()
+	if connection.Send([]byte("{}")) {
+		t.Fatal("sent to a peer not reading")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("send blocked: %v", elapsed)
+	}
+}
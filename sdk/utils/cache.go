@@ -0,0 +1,188 @@
+/* license: https://mit-license.org
+ * ==============================================================================
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2021 Albert Moky
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ * ==============================================================================
+ */
+package utils
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+/**
+ *  LRU Cache
+ *  ~~~~~~~~~
+ *
+ *  Size-bounded cache, the least recently used entry will be evicted
+ *  when it's full; entries can also be expired after a period of time.
+ *
+ *  Safe for concurrent use.
+ */
+type LRUCache struct {
+
+	_capacity int
+
+	_list *list.List                       // entries, most recent first
+	_items map[interface{}]*list.Element   // key -> entry
+
+	_lock sync.Mutex
+}
+
+type cacheEntry struct {
+	key interface{}
+	value interface{}
+	expires time.Time  // zero means never expired
+}
+
+func NewLRUCache(capacity int) *LRUCache {
+	cache := new(LRUCache)
+	cache.Init(capacity)
+	return cache
+}
+
+func (cache *LRUCache) Init(capacity int) *LRUCache {
+	if capacity < 1 {
+		capacity = 1
+	}
+	cache._capacity = capacity
+	cache._list = list.New()
+	cache._items = make(map[interface{}]*list.Element)
+	return cache
+}
+
+func (cache *LRUCache) Capacity() int {
+	cache._lock.Lock()
+	defer cache._lock.Unlock()
+	return cache._capacity
+}
+
+// Change capacity, evict the least recently used entries when shrinking
+func (cache *LRUCache) SetCapacity(capacity int) {
+	if capacity < 1 {
+		capacity = 1
+	}
+	cache._lock.Lock()
+	defer cache._lock.Unlock()
+	cache._capacity = capacity
+	cache.evict()
+}
+
+func (cache *LRUCache) Len() int {
+	cache._lock.Lock()
+	defer cache._lock.Unlock()
+	return cache._list.Len()
+}
+
+/**
+ *  Get value with key
+ *
+ * @param key - cache key
+ * @return value and true on found (value can be nil),
+ *         or nil and false when not found or expired
+ */
+func (cache *LRUCache) Get(key interface{}) (interface{}, bool) {
+	cache._lock.Lock()
+	defer cache._lock.Unlock()
+	element := cache._items[key]
+	if element == nil {
+		return nil, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		// expired
+		cache.removeElement(element)
+		return nil, false
+	}
+	cache._list.MoveToFront(element)
+	return entry.value, true
+}
+
+/**
+ *  Put value with key
+ *
+ * @param key   - cache key
+ * @param value - cache value
+ * @param ttl   - life span of this entry, 0 means forever
+ */
+func (cache *LRUCache) Put(key interface{}, value interface{}, ttl time.Duration) {
+	var expires time.Time
+	if ttl > 0 {
+		expires = time.Now().Add(ttl)
+	}
+	cache._lock.Lock()
+	defer cache._lock.Unlock()
+	element := cache._items[key]
+	if element != nil {
+		entry := element.Value.(*cacheEntry)
+		entry.value = value
+		entry.expires = expires
+		cache._list.MoveToFront(element)
+		return
+	}
+	entry := &cacheEntry{key: key, value: value, expires: expires}
+	cache._items[key] = cache._list.PushFront(entry)
+	cache.evict()
+}
+
+// Remove entry with key
+func (cache *LRUCache) Remove(key interface{}) {
+	cache._lock.Lock()
+	defer cache._lock.Unlock()
+	element := cache._items[key]
+	if element != nil {
+		cache.removeElement(element)
+	}
+}
+
+// Remove all entries with keys matched
+func (cache *LRUCache) RemoveMatched(match func(key interface{}) bool) {
+	cache._lock.Lock()
+	defer cache._lock.Unlock()
+	for key, element := range cache._items {
+		if match(key) {
+			cache.removeElement(element)
+		}
+	}
+}
+
+// Remove all entries
+func (cache *LRUCache) Clear() {
+	cache._lock.Lock()
+	defer cache._lock.Unlock()
+	cache._list.Init()
+	cache._items = make(map[interface{}]*list.Element)
+}
+
+// evict the least recently used entries
+func (cache *LRUCache) evict() {
+	for cache._list.Len() > cache._capacity {
+		cache.removeElement(cache._list.Back())
+	}
+}
+
+func (cache *LRUCache) removeElement(element *list.Element) {
+	entry := cache._list.Remove(element).(*cacheEntry)
+	delete(cache._items, entry.key)
+}
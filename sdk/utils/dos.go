@@ -26,9 +26,13 @@
 package utils
 
 import (
+	"encoding/json"
+	"fmt"
 	. "github.com/dimchat/mkm-go/format"
 	"os"
 	"path"
+	"path/filepath"
+	"time"
 )
 
 func MakeDirs(dir string) bool {
@@ -55,6 +59,27 @@ func PathRemove(path string) bool {
 	err := os.Remove(path)
 	return err == nil
 }
+func PathRename(src string, dst string) bool {
+	if os.Rename(src, dst) != nil {
+		return false
+	}
+	// flush the directory entry
+	syncDir(PathDir(dst))
+	return true
+}
+
+// Get names of all entries in the directory
+func PathListDir(dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	names := make([]string, 0, len(entries))
+	for _, item := range entries {
+		names = append(names, item.Name())
+	}
+	return names
+}
 
 //
 //  Binary File
@@ -68,20 +93,71 @@ func ReadBinaryFile(path string) []byte {
 		return nil
 	}
 }
+
+/**
+ *  Write data into a temporary file first, flush it to disk,
+ *  and then rename it to the target path,
+ *  so the target file will never be truncated by a crash
+ */
 func WriteBinaryFile(path string, data []byte) bool {
-	err := os.WriteFile(path, data, 0644)
-	return err == nil
+	dir := PathDir(path)
+	fd, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return false
+	}
+	tmp := fd.Name()
+	cnt, err := fd.Write(data)
+	if err == nil && cnt == len(data) {
+		err = fd.Sync()
+	}
+	if fd.Close() != nil || err != nil || cnt != len(data) {
+		_ = os.Remove(tmp)
+		return false
+	}
+	if os.Chmod(tmp, 0644) != nil || os.Rename(tmp, path) != nil {
+		_ = os.Remove(tmp)
+		return false
+	}
+	// flush the directory entry
+	syncDir(dir)
+	return true
 }
 func AppendBinaryFile(path string, data []byte) bool {
 	fd, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
 	if err == nil {
 		cnt, err := fd.Write(data)
+		if err == nil {
+			err = fd.Sync()
+		}
 		return fd.Close() == nil && err == nil && cnt == len(data)
 	} else {
 		return false
 	}
 }
 
+func syncDir(dir string) {
+	fd, err := os.Open(dir)
+	if err == nil {
+		_ = fd.Sync()
+		_ = fd.Close()
+	}
+}
+
+/**
+ *  Move a corrupt file aside with suffix '.corrupt-{timestamp}'
+ *
+ * @param path - corrupt file
+ * @return new path, or empty string on failed
+ */
+func PathQuarantine(path string) string {
+	target := fmt.Sprintf("%s.corrupt-%d", path, time.Now().Unix())
+	if os.Rename(path, target) == nil {
+		return target
+	} else {
+		return ""
+	}
+}
+
 //
 //  Text File
 //
@@ -110,8 +186,12 @@ func ReadJSONFile(path string) interface{} {
 	if data == nil {
 		return nil
 	}
-	json := UTF8Decode(data)
-	return JSONDecode(json)
+	var object interface{}
+	if json.Unmarshal(data, &object) != nil {
+		// JSON error
+		return nil
+	}
+	return object
 }
 func WriteJSONFile(path string, object interface{}) bool {
 	json := JSONEncode(object)
@@ -27,6 +27,7 @@ package utils
 
 import (
 	"fmt"
+	"os"
 	"time"
 )
 
@@ -45,6 +46,35 @@ const (
 
 var LogLevel = develop
 
+/**
+ *  Set log level by name: "debug", "develop" or "release"
+ */
+func SetLogLevel(level string) bool {
+	switch level {
+	case "debug":
+		LogLevel = debug
+	case "develop":
+		LogLevel = develop
+	case "release":
+		LogLevel = release
+	default:
+		return false
+	}
+	return true
+}
+
+/**
+ *  Log level can be selected by environment variable:
+ *
+ *      DIM_LOG_LEVEL - "debug", "develop" (default) or "release"
+ */
+func init() {
+	level := os.Getenv("DIM_LOG_LEVEL")
+	if level != "" && !SetLogLevel(level) {
+		fmt.Printf("unknown log level: %s\n", level)
+	}
+}
+
 func now() string {
 	current := time.Now()
 	return current.Format("2006-01-02 15:04:05")
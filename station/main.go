@@ -0,0 +1,141 @@
+/* license: https://mit-license.org
+ * ==============================================================================
+ * The MIT License (MIT)
+ *
+ * Copyright (c) 2021 Albert Moky
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ * ==============================================================================
+ */
+package main
+
+import (
+	"fmt"
+	. "github.com/dimchat/demo-go/sdk/database"
+	. "github.com/dimchat/demo-go/sdk/server"
+	. "github.com/dimchat/mkm-go/protocol"
+	"os"
+	"strconv"
+	"time"
+)
+
+func getOptionString(args []string, key string) string {
+	pos := len(args) - 1
+	for pos > 0 {
+		pos--
+		if args[pos] == key {
+			return args[pos + 1]
+		}
+	}
+	return ""
+}
+
+func showHelp(path string) {
+	fmt.Printf("\n" +
+		"\n    Usages:" +
+		"\n        %s --id <ID> [options]" +
+		"\n" +
+		"\n    Descriptions:" +
+		"\n        Run station with ID, the meta, profile and private keys should be" +
+		"\n        generated by 'register generate station' in the same storage." +
+		"\n" +
+		"\n    Options:" +
+		"\n        --id <ID>               Station ID." +
+		"\n        --host <IP>             Listening IP, default is '0.0.0.0'." +
+		"\n        --port <number>         Listening TCP port, default is 9394." +
+		"\n        --ws-port <number>      Listening WebSocket port, disabled by default." +
+		"\n        --idle-timeout <secs>   Close sessions without activity, default is 300." +
+		"\n        --inbox-quota <number>  Max offline messages for each user, default is 1024." +
+		"\n        --inbox-expires <days>  Drop offline messages older than it, default is 7." +
+		"\n        --providers <file>      Import service providers & stations at first start," +
+		"\n                                JSON only, same format as 'providers.js'." +
+		"\n" +
+		"\n    Environment Variables:" +
+		"\n        DIM_ROOT                Storage root directory." +
+		"\n        DIM_PASSPHRASE          Passphrase for private keys." +
+		"\n        DIM_LOG_LEVEL           'debug', 'develop' or 'release'." +
+		"\n        DIM_PROVIDERS           Same as '--providers'." +
+		"\n\n", path)
+}
+
+func main() {
+	path := os.Args[0]
+	args := os.Args[1:]
+	identifier := IDParse(getOptionString(args, "--id"))
+	if identifier == nil || identifier.Type() != STATION {
+		showHelp(path)
+		os.Exit(1)
+	}
+	host := getOptionString(args, "--host")
+	if host == "" {
+		host = "0.0.0.0"
+	}
+	port, _ := strconv.Atoi(getOptionString(args, "--port"))
+	if port <= 0 {
+		port = 9394
+	}
+	wsPort, _ := strconv.Atoi(getOptionString(args, "--ws-port"))
+	timeout := SessionIdleTimeout
+	if secs, _ := strconv.Atoi(getOptionString(args, "--idle-timeout")); secs > 0 {
+		timeout = time.Duration(secs) * time.Second
+	}
+	// import service providers
+	if config := getOptionString(args, "--providers"); config != "" {
+		storage, ok := SharedDatabase().(ProvidersConfig)
+		if !ok {
+			fmt.Println("!!! providers config not supported by this database")
+			os.Exit(1)
+		}
+		storage.SetProvidersConfig(config)
+	}
+	if storage, ok := SharedDatabase().(ProvidersConfig); ok && len(storage.GetProviders()) == 0 {
+		fmt.Println("!!! no service provider, please set '--providers' for first start")
+	}
+	// check station keys
+	facebook := SharedFacebook()
+	user := facebook.GetUser(identifier)
+	if user == nil || facebook.GetPrivateKeyForSignature(identifier) == nil {
+		fmt.Println("!!! station account not found:", identifier)
+		os.Exit(1)
+	}
+	facebook.SetCurrentUser(user)
+	// run
+	station := NewStation(identifier)
+	station.SessionServer().StartReaper(timeout)
+	quota, _ := strconv.Atoi(getOptionString(args, "--inbox-quota"))
+	if quota <= 0 {
+		quota = DefaultInboxQuota
+	}
+	expires := DefaultInboxExpires
+	if days, _ := strconv.Atoi(getOptionString(args, "--inbox-expires")); days > 0 {
+		expires = time.Duration(days) * 24 * time.Hour
+	}
+	station.Inbox().SetLimits(quota, expires)
+	if wsPort > 0 {
+		go func() {
+			err := station.ListenWebSocket(fmt.Sprintf("%s:%d", host, wsPort), "/")
+			fmt.Println("!!! websocket stopped:", err)
+		}()
+	}
+	err := station.ListenTCP(fmt.Sprintf("%s:%d", host, port))
+	if err != nil {
+		fmt.Println("!!! station stopped:", err)
+		os.Exit(1)
+	}
+}